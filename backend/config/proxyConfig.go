@@ -0,0 +1,21 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// TrustedProxies returns the proxy addresses or CIDRs listed in
+// TRUSTED_PROXIES. With none configured, forwarding headers are ignored and
+// the client IP is taken from the connection.
+func TrustedProxies() []string {
+	var proxies []string
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// DeleteAccount deactivates the user's account and schedules its deletion
+func DeleteAccount(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	scheduledFor, err := services.RequestAccountDeletion(userID.(int), req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPassword) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Account deactivated; it will be permanently deleted unless you cancel using the link we emailed you",
+		"scheduled_for": scheduledFor.Format(http.TimeFormat),
+	})
+}
+
+// CancelAccountDeletion restores an account during its deletion grace period
+func CancelAccountDeletion(c *gin.Context) {
+	var req models.CancelDeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.CancelAccountDeletion(req.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidCancellationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel deletion: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled; you can log in again"})
+}
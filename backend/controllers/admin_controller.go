@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// ListUsers lists and searches user accounts
+func ListUsers(c *gin.Context) {
+	var filters models.UserFilters
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+		return
+	}
+
+	if filters.Limit <= 0 || filters.Limit > 200 {
+		filters.Limit = 50
+	}
+
+	users, total, err := services.ListUsers(filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users, "total": total})
+}
+
+// GetUser retrieves a single user account
+func GetUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := services.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUserNotFound.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// DeactivateUser disables an account and ends its sessions
+func DeactivateUser(c *gin.Context) {
+	setUserActive(c, false)
+}
+
+// ActivateUser re-enables a deactivated account
+func ActivateUser(c *gin.Context) {
+	setUserActive(c, true)
+}
+
+func setUserActive(c *gin.Context, active bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := services.SetUserActive(c.GetInt("userID"), userID, active); err != nil {
+		writeAdminError(c, "Failed to update account: ", err)
+		return
+	}
+
+	message := "Account deactivated"
+	if active {
+		message = "Account activated"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// UpdateUserRole changes a user's role
+func UpdateUserRole(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	if err := services.SetUserRole(c.GetInt("userID"), userID, req.Role); err != nil {
+		writeAdminError(c, "Failed to update role: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+// UnlockUser lifts a brute-force lockout of a user's account
+func UnlockUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := services.UnlockAccount(userID, fmt.Sprintf("admin:%d", c.GetInt("userID"))); err != nil {
+		writeAdminError(c, "Failed to unlock account: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
+
+// GetSystemStats returns an overview of accounts and stored data
+func GetSystemStats(c *gin.Context) {
+	stats, err := services.GetSystemStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statistics: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+// writeAdminError maps admin service errors to HTTP responses
+func writeAdminError(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCannotModifySelf):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// GetAuditLog lists who accessed the user's data and what the user did.
+// Admins see every entry and may filter by user_id.
+func GetAuditLog(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var filters models.AuditFilters
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+		return
+	}
+
+	if filters.Limit <= 0 || filters.Limit > 500 {
+		filters.Limit = 50
+	}
+
+	isAdmin := c.GetString("role") == models.RoleAdmin
+
+	entries, err := services.GetAuditLog(userID.(int), isAdmin, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit log: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": entries})
+}
+
+// VerifyAuditLog checks the audit log hash chain for tampering
+func VerifyAuditLog(c *gin.Context) {
+	result, err := services.VerifyAuditChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
@@ -25,19 +28,26 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// Generate token for the new user
-	token, expiryTime, err := services.GenerateJWT(user.UserID)
+	setAuditUser(c, user.UserID)
+
+	// Unverified accounts can't log in under the block_login policy
+	if services.UnverifiedAccountPolicy() == services.PolicyBlockLogin {
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Registration successful; check your email to verify your account before logging in",
+			"user":    user,
+		})
+		return
+	}
+
+	// Start a session for the new user
+	tokens, err := services.StartSession(user.UserID, sessionMetadata(c, req.DeviceName))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token: " + err.Error()})
 		return
 	}
 
 	// Return success response
-	c.JSON(http.StatusCreated, models.AuthResponse{
-		Token:     token,
-		ExpiresAt: expiryTime.Format(http.TimeFormat),
-		User:      *user,
-	})
+	c.JSON(http.StatusCreated, newAuthResponse(user, tokens))
 }
 
 // Login handles user authentication
@@ -50,18 +60,134 @@ func Login(c *gin.Context) {
 	}
 
 	// Authenticate the user
-	user, token, expiryTime, err := services.LoginUser(req)
+	result, err := services.LoginUser(req, sessionMetadata(c, req.DeviceName))
 	if err != nil {
+		if writeThrottledError(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) || errors.Is(err, services.ErrAccountPendingDeletion) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to authenticate: " + err.Error()})
 		return
 	}
 
+	setAuditUser(c, result.User.UserID)
+
+	// Accounts with two-factor authentication continue at /login/mfa
+	if result.MFAChallenge != nil {
+		c.JSON(http.StatusOK, result.MFAChallenge)
+		return
+	}
+
 	// Return success response
-	c.JSON(http.StatusOK, models.AuthResponse{
-		Token:     token,
-		ExpiresAt: expiryTime.Format(http.TimeFormat),
-		User:      *user,
-	})
+	c.JSON(http.StatusOK, newAuthResponse(result.User, result.Tokens))
+}
+
+// LoginMFA completes a login with a TOTP or recovery code
+func LoginMFA(c *gin.Context) {
+	// Parse request body
+	var req models.MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, tokens, err := services.CompleteMFALogin(req, sessionMetadata(c, req.DeviceName))
+	if err != nil {
+		if writeThrottledError(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidMFAToken) || errors.Is(err, services.ErrInvalidMFACode) ||
+			errors.Is(err, services.ErrMFANotEnrolled) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrAccountInactive) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate: " + err.Error()})
+		return
+	}
+
+	setAuditUser(c, user.UserID)
+
+	// Return success response
+	c.JSON(http.StatusOK, newAuthResponse(user, tokens))
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token pair
+func Refresh(c *gin.Context) {
+	// Parse request body
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Rotate the refresh token
+	user, tokens, err := services.RefreshSession(req.RefreshToken, sessionMetadata(c, req.DeviceName))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrAccountInactive) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token: " + err.Error()})
+		return
+	}
+
+	setAuditUser(c, user.UserID)
+
+	// Return success response
+	c.JSON(http.StatusOK, newAuthResponse(user, tokens))
+}
+
+// writeThrottledError responds with 429 and Retry-After if err is a login
+// throttle, and reports whether it did
+func writeThrottledError(c *gin.Context, err error) bool {
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	return true
+}
+
+// sessionMetadata collects the client details recorded on a new session.
+// ClientIP only honors forwarding headers from TRUSTED_PROXIES.
+func sessionMetadata(c *gin.Context, deviceName string) models.SessionMetadata {
+	if deviceName == "" {
+		deviceName = c.GetHeader("X-Device-Name")
+	}
+	// The column holds 100 characters; cutting by runes keeps the name valid UTF-8
+	if runes := []rune(deviceName); len(runes) > 100 {
+		deviceName = string(runes[:100])
+	}
+
+	return models.SessionMetadata{
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		DeviceName: deviceName,
+	}
+}
+
+// newAuthResponse builds the response for endpoints that issue session tokens
+func newAuthResponse(user *models.User, tokens *models.SessionTokens) models.AuthResponse {
+	return models.AuthResponse{
+		Token:            tokens.AccessToken,
+		ExpiresAt:        tokens.AccessExpiresAt.Format(http.TimeFormat),
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresAt: tokens.RefreshExpiresAt.Format(http.TimeFormat),
+		User:             *user,
+	}
 }
 
 // Logout handles user logout
@@ -74,7 +200,7 @@ func Logout(c *gin.Context) {
 	}
 
 	// Invalidate the token
-	err := services.LogoutUser(token.(string))
+	err := services.LogoutUser(c.GetInt("userID"), token.(string))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout: " + err.Error()})
 		return
@@ -103,3 +229,147 @@ func Me(c *gin.Context) {
 	// Return user data
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
+
+// GetSessions lists the authenticated user's active sessions
+func GetSessions(c *gin.Context) {
+	// Get user and session IDs from context (set by auth middleware)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+	sessionID := c.GetInt("sessionID")
+
+	sessions, err := services.GetActiveSessions(userID.(int), sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sessions: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeSession logs out one of the authenticated user's sessions
+func RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := services.RevokeSession(userID.(int), sessionID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeAllSessions logs the authenticated user out everywhere
+func RevokeAllSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	if err := services.RevokeAllSessions(userID.(int)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// ForgotPassword sends a password reset link to the given email
+func ForgotPassword(c *gin.Context) {
+	// Parse request body
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request password reset: " + err.Error()})
+		return
+	}
+
+	// Same response whether or not the email is registered
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a reset token
+func ResetPassword(c *gin.Context) {
+	// Parse request body
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully; please log in again"})
+}
+
+// VerifyEmail confirms an email address from the link sent after registration
+func VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	if err := services.VerifyEmail(token); err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification sends a new verification link
+func ResendVerification(c *gin.Context) {
+	// Parse request body
+	var req models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ResendVerificationEmail(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email: " + err.Error()})
+		return
+	}
+
+	// Same response whether or not the email is registered
+	c.JSON(http.StatusOK, gin.H{"message": "If this email belongs to an unverified account, a new link has been sent"})
+}
+
+// setAuditUser identifies the user a public auth route acted for, so the
+// audit log can name them although the request carried no session
+func setAuditUser(c *gin.Context, userID int) {
+	c.Set("auditUserID", userID)
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// StartDataExport starts exporting all of the user's data
+func StartDataExport(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	export, err := services.StartDataExport(userID.(int))
+	if err != nil {
+		writeDataExportError(c, "Failed to start export: ", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Export started", "data": export})
+}
+
+// GetDataExport reports the status of an export and, once finished, its download link
+func GetDataExport(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	export, err := services.GetDataExport(userID.(int), exportID)
+	if err != nil {
+		writeDataExportError(c, "Failed to retrieve export: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": export})
+}
+
+// DownloadDataExport serves an export archive to the holder of a download link
+func DownloadDataExport(c *gin.Context) {
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	file, export, err := services.OpenDataExport(exportID, c.Query("token"))
+	if err != nil {
+		writeDataExportError(c, "Failed to download export: ", err)
+		return
+	}
+	defer file.Close()
+
+	// Identify the user in the audit log, since the link is used without logging in
+	c.Set("auditUserID", export.UserID)
+
+	name := fmt.Sprintf("notify-vital-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	modTime := time.Time{}
+	if export.CompletedAt != nil {
+		modTime = *export.CompletedAt
+	}
+	http.ServeContent(c.Writer, c.Request, name, modTime, file)
+}
+
+// writeDataExportError maps data export service errors to HTTP responses
+func writeDataExportError(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, services.ErrExportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrExportInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrExportNotReady):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidExportToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// maxFHIRImportSize caps the size of an imported Observation bundle
+const maxFHIRImportSize = 10 << 20
+
+// SearchFHIRObservations returns a patient's observations as a FHIR
+// searchset Bundle. Other patients' data requires a share grant covering
+// the requested codes, or staff access through an enrollment.
+func SearchFHIRObservations(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		writeFHIROutcome(c, http.StatusBadRequest, "login", "User ID not found in context")
+		return
+	}
+
+	var search models.FHIRObservationSearch
+	if err := c.ShouldBindQuery(&search); err != nil {
+		writeFHIROutcome(c, http.StatusBadRequest, "invalid", "Invalid query parameters: "+err.Error())
+		return
+	}
+
+	patientID := userID.(int)
+	if search.Patient != "" {
+		parsed, err := services.ParseFHIRPatient(search.Patient)
+		if err != nil {
+			writeFHIROutcome(c, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		patientID = parsed
+	}
+
+	if patientID != userID.(int) {
+		metrics, err := services.FHIRSearchMetrics(search.Code)
+		if err != nil {
+			writeFHIROutcome(c, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+
+		allowed, err := services.HasShareAccess(userID.(int), patientID, metrics)
+		if err != nil {
+			writeFHIROutcome(c, http.StatusInternalServerError, "exception", "Failed to check access: "+err.Error())
+			return
+		}
+		if !allowed {
+			writeFHIROutcome(c, http.StatusForbidden, "forbidden", "you do not have access to this patient's data")
+			return
+		}
+	}
+	c.Set("subjectUserID", patientID)
+
+	bundle, err := services.SearchFHIRObservations(patientID, search)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFHIRSearch) {
+			writeFHIROutcome(c, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		writeFHIROutcome(c, http.StatusInternalServerError, "exception", "Failed to search observations: "+err.Error())
+		return
+	}
+
+	writeFHIR(c, http.StatusOK, bundle)
+}
+
+// ImportFHIRObservations stores a FHIR Observation, or a Bundle of them, as
+// the authenticated user's heart rate, steps and calories samples
+func ImportFHIRObservations(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		writeFHIROutcome(c, http.StatusBadRequest, "login", "User ID not found in context")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxFHIRImportSize+1))
+	if err != nil {
+		writeFHIROutcome(c, http.StatusBadRequest, "invalid", "Failed to read request body: "+err.Error())
+		return
+	}
+	if len(body) > maxFHIRImportSize {
+		writeFHIROutcome(c, http.StatusRequestEntityTooLarge, "too-costly", "Bundle is too large")
+		return
+	}
+
+	response, err := services.ImportFHIRObservations(userID.(int), body)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFHIRResource) {
+			writeFHIROutcome(c, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		writeFHIROutcome(c, http.StatusInternalServerError, "exception", "Failed to import observations: "+err.Error())
+		return
+	}
+
+	services.SamplesIngested(userID.(int))
+
+	writeFHIR(c, http.StatusOK, response)
+}
+
+// writeFHIR sends a FHIR resource with the FHIR JSON media type
+func writeFHIR(c *gin.Context, status int, resource interface{}) {
+	c.Header("Content-Type", "application/fhir+json; charset=utf-8")
+	c.JSON(status, resource)
+}
+
+// writeFHIROutcome reports an error as a FHIR OperationOutcome
+func writeFHIROutcome(c *gin.Context, status int, code, diagnostics string) {
+	writeFHIR(c, status, services.FHIROutcome(code, diagnostics))
+}
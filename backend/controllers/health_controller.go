@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -11,13 +13,13 @@ import (
 
 // GetCurrentHealthData retrieves the latest health data for the authenticated user
 func GetCurrentHealthData(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
 	}
 
-	healthData, err := services.GetHealthDataForUser(userID.(int))
+	healthData, err := services.GetHealthDataForUser(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Health data not found: " + err.Error()})
 		return
@@ -28,7 +30,7 @@ func GetCurrentHealthData(c *gin.Context) {
 
 // GetHealthDataHistory retrieves health data history for the authenticated user
 func GetHealthDataHistory(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
@@ -41,12 +43,16 @@ func GetHealthDataHistory(c *gin.Context) {
 		return
 	}
 
+	if writeHistoryCSV(c, "health_data", healthDataCSVHeader, userID, filters, services.StreamHealthDataHistory, healthDataCSVRecord) {
+		return
+	}
+
 	// Apply default values if not provided
 	if filters.Limit <= 0 {
 		filters.Limit = 30
 	}
 
-	healthDataList, err := services.GetHealthDataHistory(userID.(int), filters)
+	healthDataList, err := services.GetHealthDataHistory(userID, filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve health data history: " + err.Error()})
 		return
@@ -80,7 +86,7 @@ func CreateHealthData(c *gin.Context) {
 
 // GetHealthDataSummary retrieves summary statistics for health data
 func GetHealthDataSummary(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
@@ -89,7 +95,7 @@ func GetHealthDataSummary(c *gin.Context) {
 	startDate := c.Query("start_date")
 	endDate := c.Query("end_date")
 
-	summary, err := services.GetHealthDataSummary(userID.(int), startDate, endDate)
+	summary, err := services.GetHealthDataSummary(userID, startDate, endDate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve health data summary: " + err.Error()})
 		return
@@ -100,7 +106,7 @@ func GetHealthDataSummary(c *gin.Context) {
 
 // GetHeartRateHistory retrieves heart rate history for the authenticated user
 func GetHeartRateHistory(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
@@ -113,17 +119,30 @@ func GetHeartRateHistory(c *gin.Context) {
 		return
 	}
 
+	if writeHistoryCSV(c, "heart_rate", heartRateCSVHeader, userID, filters, services.StreamHeartRateHistory, heartRateCSVRecord) {
+		return
+	}
+
 	// Apply default values if not provided
 	if filters.Limit <= 0 {
 		filters.Limit = 30
 	}
 
-	heartRateData, err := services.GetHeartRateHistory(userID.(int), filters)
+	heartRateData, err := services.GetHeartRateHistory(userID, filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve heart rate data: " + err.Error()})
 		return
 	}
 
+	if wantsOMH(c) {
+		points := make([]models.OMHDataPoint, 0, len(heartRateData))
+		for _, d := range heartRateData {
+			points = append(points, services.OMHHeartRateDataPoint(d))
+		}
+		c.JSON(http.StatusOK, gin.H{"data": points})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": heartRateData})
 }
 
@@ -147,12 +166,14 @@ func CreateHeartRateData(c *gin.Context) {
 		return
 	}
 
+	services.SamplesIngested(userID.(int))
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Heart rate data created successfully", "data": heartRateData})
 }
 
 // GetStepsHistory retrieves steps history for the authenticated user
 func GetStepsHistory(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
@@ -165,17 +186,30 @@ func GetStepsHistory(c *gin.Context) {
 		return
 	}
 
+	if writeHistoryCSV(c, "steps", stepsCSVHeader, userID, filters, services.StreamStepsHistory, stepsCSVRecord) {
+		return
+	}
+
 	// Apply default values if not provided
 	if filters.Limit <= 0 {
 		filters.Limit = 30
 	}
 
-	stepsData, err := services.GetStepsHistory(userID.(int), filters)
+	stepsData, err := services.GetStepsHistory(userID, filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve steps data: " + err.Error()})
 		return
 	}
 
+	if wantsOMH(c) {
+		points := make([]models.OMHDataPoint, 0, len(stepsData))
+		for _, d := range stepsData {
+			points = append(points, services.OMHStepCountDataPoint(d))
+		}
+		c.JSON(http.StatusOK, gin.H{"data": points})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": stepsData})
 }
 
@@ -199,12 +233,14 @@ func CreateStepsData(c *gin.Context) {
 		return
 	}
 
+	services.SamplesIngested(userID.(int))
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Steps data created successfully", "data": stepsData})
 }
 
 // GetCaloriesHistory retrieves calories history for the authenticated user
 func GetCaloriesHistory(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
@@ -217,17 +253,30 @@ func GetCaloriesHistory(c *gin.Context) {
 		return
 	}
 
+	if writeHistoryCSV(c, "calories", caloriesCSVHeader, userID, filters, services.StreamCaloriesHistory, caloriesCSVRecord) {
+		return
+	}
+
 	// Apply default values if not provided
 	if filters.Limit <= 0 {
 		filters.Limit = 30
 	}
 
-	caloriesData, err := services.GetCaloriesHistory(userID.(int), filters)
+	caloriesData, err := services.GetCaloriesHistory(userID, filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve calories data: " + err.Error()})
 		return
 	}
 
+	if wantsOMH(c) {
+		points := make([]models.OMHDataPoint, 0, len(caloriesData))
+		for _, d := range caloriesData {
+			points = append(points, services.OMHCaloriesBurnedDataPoint(d))
+		}
+		c.JSON(http.StatusOK, gin.H{"data": points})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": caloriesData})
 }
 
@@ -256,7 +305,7 @@ func CreateCaloriesData(c *gin.Context) {
 
 // GetActivityStatusHistory retrieves activity status history for the authenticated user
 func GetActivityStatusHistory(c *gin.Context) {
-	userID, exists := c.Get("userID")
+	userID, exists := dataOwnerID(c)
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
 		return
@@ -269,12 +318,16 @@ func GetActivityStatusHistory(c *gin.Context) {
 		return
 	}
 
+	if writeHistoryCSV(c, "activity_status", activityStatusCSVHeader, userID, filters, services.StreamActivityStatusHistory, activityStatusCSVRecord) {
+		return
+	}
+
 	// Apply default values if not provided
 	if filters.Limit <= 0 {
 		filters.Limit = 30
 	}
 
-	statusUpdates, err := services.GetActivityStatusHistory(userID.(int), filters)
+	statusUpdates, err := services.GetActivityStatusHistory(userID, filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve activity status data: " + err.Error()})
 		return
@@ -305,3 +358,78 @@ func CreateActivityStatusUpdate(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Activity status update created successfully", "data": statusUpdate})
 }
+
+// EstimateCalories fills in estimated calories for periods without device-reported values
+func EstimateCalories(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var req models.CaloriesEstimateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+
+	// Default to the last 24 hours
+	end := time.Now()
+	start := end.Add(-24 * time.Hour)
+
+	if req.StartDate != "" {
+		parsed, err := parseDateParam(req.StartDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date: " + err.Error()})
+			return
+		}
+		start = parsed
+	}
+
+	if req.EndDate != "" {
+		parsed, err := parseDateParam(req.EndDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date: " + err.Error()})
+			return
+		}
+		if parsed.Before(end) {
+			end = parsed
+		}
+	}
+
+	estimated, err := services.EstimateCalories(userID.(int), start, end)
+	if err != nil {
+		if errors.Is(err, services.ErrProfileIncomplete) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate calories: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calories estimated successfully", "data": estimated})
+}
+
+// dataOwnerID returns the user whose data a read request is for: the
+// subject of a shared-data route, or else the authenticated user
+func dataOwnerID(c *gin.Context) (int, bool) {
+	if subjectUserID, exists := c.Get("subjectUserID"); exists {
+		return subjectUserID.(int), true
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		return 0, false
+	}
+	return userID.(int), true
+}
+
+// parseDateParam accepts either a plain date or an RFC 3339 timestamp
+func parseDateParam(value string) (time.Time, error) {
+	if parsed, err := time.Parse("2006-01-02", value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+const mimeCSV = "text/csv"
+
+// wantsCSV reports whether a history request asked for CSV, either with
+// ?format=csv or an Accept header preferring text/csv. An explicit format
+// parameter wins over the Accept header.
+func wantsCSV(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return format == "csv"
+	}
+	return c.NegotiateFormat(gin.MIMEJSON, mimeCSV) == mimeCSV
+}
+
+// streamCSV writes a CSV attachment row by row as stream produces records.
+// Nothing is sent until the first record, so an error before then still
+// gets a JSON error response; after that the response is cut short.
+func streamCSV(c *gin.Context, name string, header []string, stream func(write func([]string) error) error) {
+	writer := csv.NewWriter(c.Writer)
+	started := false
+
+	start := func() error {
+		started = true
+		c.Header("Content-Type", mimeCSV+"; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		c.Status(http.StatusOK)
+		return writer.Write(header)
+	}
+
+	err := stream(func(record []string) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return writer.Write(record)
+	})
+
+	// An empty range still gets the header row
+	if err == nil && !started {
+		err = start()
+	}
+
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+
+	if err != nil {
+		if !started {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export " + name + ": " + err.Error()})
+			return
+		}
+		log.Printf("CSV export of %s stopped early: %v", name, err)
+		c.Abort()
+	}
+}
+
+// writeHistoryCSV answers a history request that asked for CSV with the
+// whole filtered range, ignoring paging, streamed row by row. It reports
+// whether the request was answered.
+func writeHistoryCSV[T any](
+	c *gin.Context, name string, header []string, userID int, filters models.HealthDataFilters,
+	stream func(int, models.HealthDataFilters, func(T) error) error, record func(T) []string,
+) bool {
+	if !wantsCSV(c) {
+		return false
+	}
+
+	filters.Limit, filters.Offset = 0, 0
+	streamCSV(c, name, header, func(write func([]string) error) error {
+		return stream(userID, filters, func(d T) error {
+			return write(record(d))
+		})
+	})
+	return true
+}
+
+var healthDataCSVHeader = []string{
+	"data_id", "timestamp", "device_id", "heart_rate", "steps", "calories_burned",
+	"activity_status", "activity_gauge_value", "source", "created_at",
+}
+
+func healthDataCSVRecord(d models.HealthData) []string {
+	return []string{
+		strconv.Itoa(d.DataID), csvTime(d.Timestamp), csvInt(d.DeviceID), csvInt(d.HeartRate), csvInt(d.Steps),
+		csvInt(d.CaloriesBurned), csvText(d.ActivityStatus), strconv.FormatFloat(d.ActivityGaugeValue, 'f', -1, 64),
+		csvText(d.Source), csvTime(d.CreatedAt),
+	}
+}
+
+var heartRateCSVHeader = []string{
+	"id", "timestamp", "device_id", "heart_rate", "activity_type", "workout_id", "source", "created_at",
+}
+
+func heartRateCSVRecord(d models.HeartRateData) []string {
+	return []string{
+		strconv.Itoa(d.ID), csvTime(d.Timestamp), csvInt(d.DeviceID), strconv.Itoa(d.HeartRate),
+		csvString(d.ActivityType), csvInt(d.WorkoutID), csvText(d.Source), csvTime(d.CreatedAt),
+	}
+}
+
+var stepsCSVHeader = []string{
+	"id", "timestamp", "device_id", "steps_count", "distance", "distance_estimated", "workout_id", "source",
+	"created_at",
+}
+
+func stepsCSVRecord(d models.StepsData) []string {
+	distance := ""
+	if d.Distance != nil {
+		distance = strconv.FormatFloat(*d.Distance, 'f', 2, 64)
+	}
+
+	return []string{
+		strconv.Itoa(d.ID), csvTime(d.Timestamp), csvInt(d.DeviceID), strconv.Itoa(d.StepsCount),
+		distance, strconv.FormatBool(d.DistanceEstimated), csvInt(d.WorkoutID), csvText(d.Source), csvTime(d.CreatedAt),
+	}
+}
+
+var caloriesCSVHeader = []string{
+	"id", "timestamp", "device_id", "calories_burned", "activity_type", "workout_id", "is_estimated", "source",
+	"created_at",
+}
+
+func caloriesCSVRecord(d models.CaloriesData) []string {
+	return []string{
+		strconv.Itoa(d.ID), csvTime(d.Timestamp), csvInt(d.DeviceID), strconv.Itoa(d.CaloriesBurned),
+		csvString(d.ActivityType), csvInt(d.WorkoutID), strconv.FormatBool(d.IsEstimated), csvText(d.Source),
+		csvTime(d.CreatedAt),
+	}
+}
+
+var activityStatusCSVHeader = []string{
+	"id", "timestamp", "previous_status", "current_status", "status_change_reason", "source", "created_at",
+}
+
+func activityStatusCSVRecord(d models.ActivityStatusUpdate) []string {
+	return []string{
+		strconv.Itoa(d.ID), csvTime(d.Timestamp), csvString(d.PreviousStatus), csvText(d.CurrentStatus),
+		csvString(d.StatusChangeReason), csvText(d.Source), csvTime(d.CreatedAt),
+	}
+}
+
+func csvTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+func csvInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func csvString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return csvText(*v)
+}
+
+// csvText escapes a free-text cell, which clients control, so spreadsheets
+// don't evaluate it as a formula
+func csvText(v string) string {
+	return services.SpreadsheetSafe(v)
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// StartAppleHealthImport uploads an Apple Health export.zip or export.xml
+// and imports its heart rate, steps and active energy in the background
+func StartAppleHealthImport(c *gin.Context) {
+	startImport(c, models.ImportKindAppleHealth)
+}
+
+// StartActivityFileImport uploads a FIT, TCX or GPX activity file and
+// imports each activity in it as a completed workout
+func StartActivityFileImport(c *gin.Context) {
+	startImport(c, models.ImportKindActivityFile)
+}
+
+// StartGoogleFitImport uploads a Google Takeout Fit export and imports its
+// activity metrics and sessions in the background
+func StartGoogleFitImport(c *gin.Context) {
+	startImport(c, models.ImportKindGoogleFit)
+}
+
+// GetImportJobs lists the user's imports
+func GetImportJobs(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	jobs, err := services.GetImportJobs(userID.(int))
+	if err != nil {
+		writeImportError(c, "Failed to retrieve imports: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": jobs})
+}
+
+// GetImportJob reports the progress of an import
+func GetImportJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	jobID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+
+	job, err := services.GetImportJob(userID.(int), jobID)
+	if err != nil {
+		writeImportError(c, "Failed to retrieve import: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
+
+// startImport streams an uploaded file to disk and starts importing it. The
+// file is either the "file" field of a multipart form or the raw request
+// body, named by the filename query parameter.
+func startImport(c *gin.Context, kind string) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var file io.Reader = c.Request.Body
+	fileName := c.DefaultQuery("filename", kind)
+
+	// Read multipart uploads part by part rather than buffering the form
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload: " + err.Error()})
+			return
+		}
+
+		file = nil
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload: " + err.Error()})
+				return
+			}
+			if part.FormName() == "file" {
+				file = part
+				fileName = part.FileName()
+				break
+			}
+		}
+
+		if file == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload must include a file field"})
+			return
+		}
+	}
+
+	job, err := services.StartImport(userID.(int), kind, fileName, file)
+	if err != nil {
+		writeImportError(c, "Failed to start import: ", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Import started", "data": job})
+}
+
+// writeImportError maps import service errors to HTTP responses
+func writeImportError(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, services.ErrImportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrImportInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrImportTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnsupportedImport), errors.Is(err, services.ErrInvalidImportFile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
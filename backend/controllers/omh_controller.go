@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// maxOMHImportSize caps the size of an Open mHealth ingestion request
+const maxOMHImportSize = 10 << 20
+
+// wantsOMH reports whether a history request asked for Open mHealth data
+// points with ?format=omh
+func wantsOMH(c *gin.Context) bool {
+	return c.Query("format") == "omh"
+}
+
+// IngestOMHDataPoints stores Open mHealth heart-rate, step-count,
+// calories-burned and physical-activity data points for the authenticated user
+func IngestOMHDataPoints(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxOMHImportSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body: " + err.Error()})
+		return
+	}
+	if len(body) > maxOMHImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+		return
+	}
+
+	result, err := services.ImportOMHDataPoints(userID.(int), body)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidOMHDataPoint) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ingest data points: " + err.Error()})
+		return
+	}
+
+	if result.Created > 0 {
+		services.SamplesIngested(userID.(int))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Data points ingested", "data": result})
+}
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// CreateOrganization creates an organization with the caller as its admin
+func CreateOrganization(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var req models.CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	org, err := services.CreateOrganization(userID.(int), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": org})
+}
+
+// GetOrganizations lists the organizations the user belongs to
+func GetOrganizations(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	orgs, err := services.GetOrganizations(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve organizations: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": orgs})
+}
+
+// GetOrganization retrieves an organization
+func GetOrganization(c *gin.Context) {
+	org, err := services.GetOrganization(c.GetInt("orgID"), c.GetInt("userID"))
+	if err != nil {
+		writeOrganizationError(c, "Failed to retrieve organization: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": org})
+}
+
+// GetOrgMembers lists an organization's staff
+func GetOrgMembers(c *gin.Context) {
+	members, err := services.GetOrgMembers(c.GetInt("orgID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve members: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": members})
+}
+
+// AddOrgMember adds a clinician or admin to an organization
+func AddOrgMember(c *gin.Context) {
+	var req models.AddOrgMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	member, err := services.AddOrgMember(c.GetInt("orgID"), req)
+	if err != nil {
+		writeOrganizationError(c, "Failed to add member: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": member})
+}
+
+// RemoveOrgMember removes a member from an organization
+func RemoveOrgMember(c *gin.Context) {
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := services.RemoveOrgMember(c.GetInt("orgID"), memberID); err != nil {
+		writeOrganizationError(c, "Failed to remove member: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// GetPatientPanel lists the organization's patients with their latest data
+func GetPatientPanel(c *gin.Context) {
+	var filters models.PatientPanelFilters
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+		return
+	}
+
+	panel, err := services.GetPatientPanel(c.GetInt("orgID"), filters.Sort)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve patients: " + err.Error()})
+		return
+	}
+
+	// Each patient's audit log shows that their vitals were read
+	patientIDs := make([]int, len(panel))
+	for i, entry := range panel {
+		patientIDs[i] = entry.PatientID
+	}
+	c.Set("auditHealthReads", patientIDs)
+
+	c.JSON(http.StatusOK, gin.H{"data": panel})
+}
+
+// EnrollPatient invites a patient to the organization
+func EnrollPatient(c *gin.Context) {
+	var req models.EnrollPatientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	enrollment, err := services.EnrollPatient(c.GetInt("orgID"), c.GetInt("userID"), req)
+	if err != nil {
+		writeOrganizationError(c, "Failed to enroll patient: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Invitation sent; the patient must consent", "data": enrollment})
+}
+
+// RemovePatient ends a patient's enrollment in the organization
+func RemovePatient(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("patientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return
+	}
+
+	if err := services.RemovePatient(c.GetInt("orgID"), patientID); err != nil {
+		writeOrganizationError(c, "Failed to remove patient: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Patient removed"})
+}
+
+// AcknowledgePatientAlert closes an open alert of an enrolled patient
+func AcknowledgePatientAlert(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("patientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return
+	}
+
+	alertID, err := strconv.Atoi(c.Param("alertId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID"})
+		return
+	}
+
+	alert, err := services.AcknowledgePatientAlert(c.GetInt("orgID"), patientID, alertID, c.GetInt("userID"))
+	if err != nil {
+		writeOrganizationError(c, "Failed to acknowledge alert: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": alert})
+}
+
+// GetMyEnrollments lists the organizations the user is enrolled in or invited to
+func GetMyEnrollments(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	enrollments, err := services.GetPatientEnrollments(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve enrollments: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": enrollments})
+}
+
+// ConsentToEnrollment lets an organization's staff see the user's data
+func ConsentToEnrollment(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	enrollmentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid enrollment ID"})
+		return
+	}
+
+	enrollment, err := services.ConsentToEnrollment(userID.(int), enrollmentID)
+	if err != nil {
+		writeOrganizationError(c, "Failed to consent: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+}
+
+// WithdrawEnrollment declines an invitation or withdraws consent
+func WithdrawEnrollment(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	enrollmentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid enrollment ID"})
+		return
+	}
+
+	if err := services.WithdrawEnrollment(userID.(int), enrollmentID); err != nil {
+		writeOrganizationError(c, "Failed to withdraw: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Enrollment withdrawn"})
+}
+
+// writeOrganizationError maps organization service errors to HTTP responses
+func writeOrganizationError(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, services.ErrOrganizationNotFound), errors.Is(err, services.ErrOrgMemberNotFound),
+		errors.Is(err, services.ErrEnrollmentNotFound), errors.Is(err, services.ErrUserNotFound),
+		errors.Is(err, services.ErrAlertNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAlreadyOrgMember), errors.Is(err, services.ErrAlreadyEnrolled),
+		errors.Is(err, services.ErrLastOrgAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotClinician):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
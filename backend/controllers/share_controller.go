@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// CreateShareGrant invites another account to read the user's data
+func CreateShareGrant(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var req models.CreateShareGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	grant, err := services.CreateShareGrant(userID.(int), req)
+	if err != nil {
+		writeShareError(c, "Failed to share data: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Invitation sent", "data": grant})
+}
+
+// GetShareGrants lists the grants the user has given
+func GetShareGrants(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	grants, err := services.GetShareGrants(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shares: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": grants})
+}
+
+// GetSharedWithMe lists the grants and invitations the user has received
+func GetSharedWithMe(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	grants, err := services.GetSharedWithMe(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shares: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": grants})
+}
+
+// AcceptShareGrant accepts an invitation to read another user's data
+func AcceptShareGrant(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	grantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	grant, err := services.AcceptShareGrant(userID.(int), grantID)
+	if err != nil {
+		writeShareError(c, "Failed to accept share: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": grant})
+}
+
+// RevokeShareGrant revokes a grant the user gave or received
+func RevokeShareGrant(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	grantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	if err := services.RevokeShareGrant(userID.(int), grantID); err != nil {
+		writeShareError(c, "Failed to revoke share: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share revoked"})
+}
+
+// writeShareError maps sharing service errors to HTTP responses
+func writeShareError(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, services.ErrShareGrantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCannotShareWithSelf), errors.Is(err, services.ErrInvalidShareExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "please verify your email address before accepting shared data"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
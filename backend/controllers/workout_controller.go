@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// StartWorkout starts a new workout session for the authenticated user
+func StartWorkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var req models.StartWorkoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	workout, err := services.StartWorkout(userID.(int), req)
+	if err != nil {
+		if errors.Is(err, services.ErrWorkoutInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start workout: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Workout started successfully", "data": workout})
+}
+
+// StopWorkout stops an active workout and returns its computed metrics
+func StopWorkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	workoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workout ID"})
+		return
+	}
+
+	workout, err := services.StopWorkout(userID.(int), workoutID)
+	if err != nil {
+		writeWorkoutError(c, "Failed to stop workout: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workout stopped successfully", "data": workout})
+}
+
+// GetWorkouts lists the workouts of the authenticated user
+func GetWorkouts(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var filters models.WorkoutFilters
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+		return
+	}
+
+	if filters.Limit <= 0 {
+		filters.Limit = 30
+	}
+
+	workouts, err := services.GetWorkouts(userID.(int), filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workouts: " + err.Error()})
+		return
+	}
+
+	if wantsOMH(c) {
+		points := make([]models.OMHDataPoint, 0, len(workouts))
+		for _, workout := range workouts {
+			points = append(points, services.OMHPhysicalActivityDataPoint(workout))
+		}
+		c.JSON(http.StatusOK, gin.H{"data": points})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": workouts})
+}
+
+// GetWorkout retrieves a single workout of the authenticated user
+func GetWorkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	workoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workout ID"})
+		return
+	}
+
+	workout, err := services.GetWorkoutByID(userID.(int), workoutID)
+	if err != nil {
+		writeWorkoutError(c, "Failed to retrieve workout: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": workout})
+}
+
+// ConfirmWorkout confirms an automatically detected workout
+func ConfirmWorkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	workoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workout ID"})
+		return
+	}
+
+	workout, err := services.ConfirmWorkout(userID.(int), workoutID)
+	if err != nil {
+		writeWorkoutError(c, "Failed to confirm workout: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workout confirmed successfully", "data": workout})
+}
+
+// UpdateWorkout relabels a workout with a different workout type
+func UpdateWorkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	workoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workout ID"})
+		return
+	}
+
+	var req models.UpdateWorkoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	workout, err := services.RelabelWorkout(userID.(int), workoutID, req)
+	if err != nil {
+		writeWorkoutError(c, "Failed to update workout: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workout updated successfully", "data": workout})
+}
+
+// DiscardWorkout rejects an automatically detected workout
+func DiscardWorkout(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	workoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workout ID"})
+		return
+	}
+
+	if err := services.DiscardWorkout(userID.(int), workoutID); err != nil {
+		writeWorkoutError(c, "Failed to discard workout: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workout discarded successfully"})
+}
+
+// writeWorkoutError maps workout service errors to HTTP responses
+func writeWorkoutError(c *gin.Context, prefix string, err error) {
+	switch {
+	case errors.Is(err, services.ErrWorkoutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWorkoutNotActive), errors.Is(err, services.ErrWorkoutNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/middleware"
 	"github.com/habdil/notify-vital/backend/routes"
+	"github.com/habdil/notify-vital/backend/services"
 )
 
 func main() {
@@ -25,6 +27,13 @@ func main() {
 	}
 	defer config.CloseDB()
 
+	// Purge accounts whose deletion grace period has passed
+	services.StartAccountPurger()
+
+	// Exports and imports cut short by a restart would otherwise block new ones
+	services.FailInterruptedExports()
+	services.FailInterruptedImports()
+
 	// Set Gin mode based on environment
 	env := os.Getenv("ENV")
 	if env == "production" {
@@ -36,12 +45,27 @@ func main() {
 	// Initialize Gin router
 	router := gin.Default()
 
+	// Only trust forwarding headers set by known proxies
+	if err := router.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Apply CORS middleware
 	router.Use(config.SetupCORS())
 
+	// Per-IP limit across the whole API, on top of the per-group limits
+	router.Use(middleware.RateLimit("global"))
+
 	// Setup routes
 	routes.SetupAuthRoutes(router)
 	routes.SetupHealthRoutes(router)
+	routes.SetupWorkoutRoutes(router)
+	routes.SetupUserRoutes(router)
+	routes.SetupSharedDataRoutes(router)
+	routes.SetupFHIRRoutes(router)
+	routes.SetupOrganizationRoutes(router)
+	routes.SetupAuditRoutes(router)
+	routes.SetupAdminRoutes(router)
 
 	// Get port from environment variable or use default
 	port := os.Getenv("PORT")
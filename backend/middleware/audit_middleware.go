@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// AuditLog records every request of a route group in the audit log once it
+// has been handled. The action is the category plus "read", "write" or
+// "delete". The actor is the authenticated user, or the user a public auth
+// route identified ("auditUserID"); the subject is the user whose data was
+// accessed ("subjectUserID"), defaulting to the actor. Handlers that read
+// several users' vitals at once list them in "auditHealthReads", and each
+// gets its own health.read entry.
+//
+// Entries are written after the response has been sent, so a failed write
+// cannot change it; the error is attached to the request for the access log.
+func AuditLog(category string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		entry := models.AuditEntry{
+			Action:     category + "." + auditVerb(c.Request.Method),
+			Resource:   c.FullPath(),
+			Method:     c.Request.Method,
+			StatusCode: c.Writer.Status(),
+			IPAddress:  c.ClientIP(),
+			CreatedAt:  time.Now(),
+		}
+
+		if userID, exists := c.Get("userID"); exists {
+			actorID := userID.(int)
+			entry.ActorID = &actorID
+		} else if userID, exists := c.Get("auditUserID"); exists {
+			actorID := userID.(int)
+			entry.ActorID = &actorID
+		}
+
+		entry.SubjectUserID = entry.ActorID
+		if subjectUserID, exists := c.Get("subjectUserID"); exists {
+			subjectID := subjectUserID.(int)
+			entry.SubjectUserID = &subjectID
+		}
+
+		if entry.Resource == "" {
+			entry.Resource = c.Request.URL.Path
+		}
+
+		entries := []models.AuditEntry{entry}
+		if subjectUserIDs, exists := c.Get("auditHealthReads"); exists {
+			for _, subjectID := range subjectUserIDs.([]int) {
+				read := entry
+				read.Action = "health.read"
+				read.SubjectUserID = &subjectID
+				entries = append(entries, read)
+			}
+		}
+
+		if err := services.RecordAudit(entries...); err != nil {
+			_ = c.Error(fmt.Errorf("audit log write failed: %w", err))
+		}
+	}
+}
+
+// auditVerb classifies a request method as a read, write or delete
+func auditVerb(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "read"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "write"
+	}
+}
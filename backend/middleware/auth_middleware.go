@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -30,15 +31,30 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// Extract and validate the token
 		token := parts[1]
-		userID, err := services.ValidateJWT(token)
+		claims, err := services.ValidateJWT(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		// Set the user ID in the context for later use
-		c.Set("userID", userID)
+		// Reject tokens whose session was logged out or revoked
+		sessionID, role, err := services.ValidateSession(claims)
+		if errors.Is(err, services.ErrSessionRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session has been revoked"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate session: " + err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Set the user, role and session ID in the context for later use
+		c.Set("userID", claims.UserID)
+		c.Set("role", role)
+		c.Set("sessionID", sessionID)
 		c.Set("token", token)
 
 		// Continue to the next handler
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// RequireOrgMember restricts a route to members of the organization in the
+// :id parameter, optionally with one of the given organization roles. Sets
+// "orgID" and "orgRole". Must run after AuthMiddleware.
+func RequireOrgMember(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			c.Abort()
+			return
+		}
+
+		role, err := services.GetOrgRole(orgID, c.GetInt("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership: " + err.Error()})
+			c.Abort()
+			return
+		}
+
+		if role == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are not a member of this organization"})
+			c.Abort()
+			return
+		}
+
+		if len(roles) > 0 {
+			allowed := false
+			for _, r := range roles {
+				if role == r {
+					allowed = true
+					break
+				}
+			}
+
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "you do not have permission to manage this organization"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("orgID", orgID)
+		c.Set("orgRole", role)
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// RateLimit applies the named token-bucket policy (see
+// services.RateLimitPolicyFor). Requests are counted per user when run after
+// AuthMiddleware, and per client IP otherwise.
+func RateLimit(policyName string) gin.HandlerFunc {
+	policy := services.RateLimitPolicyFor(policyName)
+
+	return func(c *gin.Context) {
+		if policy.Limit == 0 {
+			c.Next()
+			return
+		}
+
+		subject := "ip:" + c.ClientIP()
+		if userID, exists := c.Get("userID"); exists {
+			subject = fmt.Sprintf("user:%d", userID.(int))
+		}
+
+		result, err := services.GetRateLimitStore().Take(policy.Name+":"+subject, policy, time.Now())
+		if err != nil {
+			// Fail open; an unavailable limiter shouldn't take the API down
+			log.Printf("Rate limiter error: %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, ceilSeconds(policy.Window)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// RequireShareGrant guards routes that read the data of the user in the :id
+// parameter. The caller must be that user, hold a grant from them covering
+// all of the metrics, or be staff of an organization they are enrolled in.
+// The user whose data is read is set as "subjectUserID". Must run after
+// AuthMiddleware.
+func RequireShareGrant(metrics ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subjectUserID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			c.Abort()
+			return
+		}
+
+		userID := c.GetInt("userID")
+		if subjectUserID != userID {
+			allowed, err := services.HasShareAccess(userID, subjectUserID, metrics)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access: " + err.Error()})
+				c.Abort()
+				return
+			}
+
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "you do not have access to this user's data"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("subjectUserID", subjectUserID)
+		c.Next()
+	}
+}
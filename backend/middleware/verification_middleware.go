@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/services"
+)
+
+// RequireVerifiedEmail rejects requests from accounts whose email is not
+// verified, unless UNVERIFIED_ACCOUNT_POLICY allows them. Must run after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if services.UnverifiedAccountPolicy() == services.PolicyAllow {
+			c.Next()
+			return
+		}
+
+		verified, err := services.IsEmailVerified(c.GetInt("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email verification: " + err.Error()})
+			c.Abort()
+			return
+		}
+
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "please verify your email address before recording health data"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
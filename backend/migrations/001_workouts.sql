@@ -0,0 +1,26 @@
+-- Workout sessions and links from metric samples to the session they belong to
+
+CREATE TABLE IF NOT EXISTS workouts (
+    workout_id        SERIAL PRIMARY KEY,
+    user_id           INTEGER NOT NULL REFERENCES users(user_id),
+    device_id         INTEGER,
+    workout_type      VARCHAR(50) NOT NULL,
+    status            VARCHAR(20) NOT NULL DEFAULT 'active',
+    start_time        TIMESTAMP NOT NULL,
+    end_time          TIMESTAMP,
+    duration_seconds  INTEGER NOT NULL DEFAULT 0,
+    avg_heart_rate    DOUBLE PRECISION,
+    max_heart_rate    INTEGER,
+    total_steps       INTEGER NOT NULL DEFAULT 0,
+    distance          DOUBLE PRECISION NOT NULL DEFAULT 0,
+    calories_burned   INTEGER NOT NULL DEFAULT 0,
+    heart_rate_zones  JSONB NOT NULL DEFAULT '{}'::jsonb,
+    created_at        TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_workouts_user_start ON workouts (user_id, start_time DESC);
+
+ALTER TABLE heart_rate_data ADD COLUMN IF NOT EXISTS workout_id INTEGER REFERENCES workouts(workout_id) ON DELETE SET NULL;
+ALTER TABLE steps_data ADD COLUMN IF NOT EXISTS workout_id INTEGER REFERENCES workouts(workout_id) ON DELETE SET NULL;
+ALTER TABLE calories_data ADD COLUMN IF NOT EXISTS workout_id INTEGER REFERENCES workouts(workout_id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+-- Automatically detected workouts start out tentative until the user confirms or discards them
+
+ALTER TABLE workouts ADD COLUMN IF NOT EXISTS detected BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_user_end ON workouts (user_id, end_time DESC);
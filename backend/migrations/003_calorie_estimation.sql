@@ -0,0 +1,7 @@
+-- User profile fields used for energy expenditure estimation, and a flag for estimated calories
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS date_of_birth DATE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS weight_kg DOUBLE PRECISION;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sex VARCHAR(10);
+
+ALTER TABLE calories_data ADD COLUMN IF NOT EXISTS is_estimated BOOLEAN NOT NULL DEFAULT false;
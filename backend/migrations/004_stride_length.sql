@@ -0,0 +1,5 @@
+-- User profile fields used to derive distance from step counts
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS height_cm DOUBLE PRECISION;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS walking_stride_cm DOUBLE PRECISION;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS running_stride_cm DOUBLE PRECISION;
@@ -0,0 +1,10 @@
+-- Refresh tokens: each sessions row holds one generation of a rotating refresh token.
+-- Rows created from the same login share a family_id so reuse can revoke them together.
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_token_hash VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_expires_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS family_id VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_token_hash ON sessions (refresh_token_hash);
+CREATE INDEX IF NOT EXISTS idx_sessions_family_id ON sessions (family_id);
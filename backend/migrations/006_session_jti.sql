@@ -0,0 +1,6 @@
+-- Access tokens carry the jti of their session row so revocation can be enforced per request
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS jti VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_jti ON sessions (jti);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_valid ON sessions (user_id, is_valid);
@@ -0,0 +1,4 @@
+-- Request metadata recorded when a session is created
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_name VARCHAR(100);
@@ -0,0 +1,20 @@
+-- TOTP two-factor authentication and one-time recovery codes
+
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id         INTEGER PRIMARY KEY REFERENCES users(user_id),
+    secret          VARCHAR(64) NOT NULL,
+    enabled         BOOLEAN NOT NULL DEFAULT false,
+    last_used_step  BIGINT NOT NULL DEFAULT 0,
+    confirmed_at    TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL REFERENCES users(user_id),
+    code_hash   VARCHAR(64) NOT NULL,
+    used_at     TIMESTAMP,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes (user_id);
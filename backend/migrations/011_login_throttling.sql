@@ -0,0 +1,23 @@
+-- Failed login tracking shared across instances, and an audit trail of lockouts
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    throttle_key     VARCHAR(320) PRIMARY KEY,
+    failures         INTEGER NOT NULL DEFAULT 0,
+    last_failure_at  TIMESTAMP NOT NULL,
+    locked_until     TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER REFERENCES users(user_id),
+    scope        VARCHAR(20) NOT NULL,
+    email        VARCHAR(255),
+    ip_address   VARCHAR(45),
+    failures     INTEGER NOT NULL,
+    locked_until TIMESTAMP NOT NULL,
+    unlocked_at  TIMESTAMP,
+    unlocked_by  VARCHAR(100),
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_lockouts_user ON account_lockouts (user_id);
@@ -0,0 +1,9 @@
+-- Token buckets for rate limits shared across instances
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key  VARCHAR(255) PRIMARY KEY,
+    tokens      DOUBLE PRECISION NOT NULL,
+    updated_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated ON rate_limit_buckets (updated_at);
@@ -0,0 +1,7 @@
+-- Roles for role-based access control. Grant the first admin manually:
+--   UPDATE users SET role = 'admin' WHERE email = '...';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'caregiver', 'clinician', 'admin'));
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role);
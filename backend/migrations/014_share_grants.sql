@@ -0,0 +1,17 @@
+-- Read access to a user's health data granted to another account (e.g. a caregiver)
+
+CREATE TABLE IF NOT EXISTS share_grants (
+    id             SERIAL PRIMARY KEY,
+    owner_id       INTEGER NOT NULL REFERENCES users(user_id),
+    grantee_email  VARCHAR(255) NOT NULL,
+    grantee_id     INTEGER REFERENCES users(user_id),
+    metrics        TEXT[] NOT NULL,
+    expires_at     TIMESTAMP,
+    accepted_at    TIMESTAMP,
+    revoked_at     TIMESTAMP,
+    created_at     TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_grants_owner ON share_grants (owner_id);
+CREATE INDEX IF NOT EXISTS idx_share_grants_grantee ON share_grants (grantee_id);
+CREATE INDEX IF NOT EXISTS idx_share_grants_grantee_email ON share_grants (LOWER(grantee_email));
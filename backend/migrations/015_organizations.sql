@@ -0,0 +1,34 @@
+-- Organizations (e.g. clinics), their staff, and patients enrolled with consent
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id          SERIAL PRIMARY KEY,
+    name        VARCHAR(100) NOT NULL,
+    created_by  INTEGER NOT NULL REFERENCES users(user_id),
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    org_id     INTEGER NOT NULL REFERENCES organizations(id),
+    user_id    INTEGER NOT NULL REFERENCES users(user_id),
+    role       VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'clinician')),
+    joined_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_members_user ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS patient_enrollments (
+    id            SERIAL PRIMARY KEY,
+    org_id        INTEGER NOT NULL REFERENCES organizations(id),
+    patient_id    INTEGER NOT NULL REFERENCES users(user_id),
+    invited_by    INTEGER NOT NULL REFERENCES users(user_id),
+    status        VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'revoked')),
+    consented_at  TIMESTAMP,
+    revoked_at    TIMESTAMP,
+    created_at    TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A patient has at most one open enrollment per organization
+CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_enrollments_open
+    ON patient_enrollments (org_id, patient_id) WHERE status <> 'revoked';
+CREATE INDEX IF NOT EXISTS idx_patient_enrollments_patient ON patient_enrollments (patient_id);
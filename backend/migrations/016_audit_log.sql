@@ -0,0 +1,30 @@
+-- Append-only, hash-chained audit log of access to personal data.
+-- There are deliberately no foreign keys: entries outlive deleted accounts.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id               BIGSERIAL PRIMARY KEY,
+    actor_id         INTEGER,
+    subject_user_id  INTEGER,
+    action           VARCHAR(50) NOT NULL,
+    resource         VARCHAR(255) NOT NULL,
+    method           VARCHAR(10) NOT NULL,
+    status_code      INTEGER NOT NULL,
+    ip_address       VARCHAR(45),
+    created_at       TIMESTAMP NOT NULL,
+    prev_hash        VARCHAR(64) NOT NULL,
+    hash             VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_subject ON audit_log (subject_user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor_id, created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
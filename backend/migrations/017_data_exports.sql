@@ -0,0 +1,14 @@
+-- Asynchronous personal data export jobs
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER NOT NULL REFERENCES users(user_id),
+    status        VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    file_path     VARCHAR(500),
+    error         TEXT,
+    expires_at    TIMESTAMP,
+    created_at    TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at  TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user ON data_exports (user_id);
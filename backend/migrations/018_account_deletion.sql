@@ -0,0 +1,11 @@
+-- Account deletion requests, purged after a grace period
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_for TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled ON users (deletion_scheduled_for)
+    WHERE deletion_scheduled_for IS NOT NULL;
+
+-- Organizations and enrollments outlive the staff account that created them
+ALTER TABLE organizations ALTER COLUMN created_by DROP NOT NULL;
+ALTER TABLE patient_enrollments ALTER COLUMN invited_by DROP NOT NULL;
@@ -0,0 +1,31 @@
+-- Background import jobs for health data exported from other platforms, and
+-- links from imported samples to the job that created them
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id                SERIAL PRIMARY KEY,
+    user_id           INTEGER NOT NULL REFERENCES users(user_id),
+    kind              VARCHAR(30) NOT NULL,
+    status            VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    file_name         VARCHAR(255) NOT NULL,
+    file_path         VARCHAR(500),
+    bytes_total       BIGINT NOT NULL DEFAULT 0,
+    bytes_processed   BIGINT NOT NULL DEFAULT 0,
+    records_found     INTEGER NOT NULL DEFAULT 0,
+    records_imported  INTEGER NOT NULL DEFAULT 0,
+    records_skipped   INTEGER NOT NULL DEFAULT 0,
+    error             TEXT,
+    created_at        TIMESTAMP NOT NULL DEFAULT NOW(),
+    started_at        TIMESTAMP,
+    completed_at      TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_user ON import_jobs (user_id, created_at DESC);
+
+ALTER TABLE heart_rate_data ADD COLUMN IF NOT EXISTS import_job_id INTEGER REFERENCES import_jobs(id) ON DELETE SET NULL;
+ALTER TABLE steps_data ADD COLUMN IF NOT EXISTS import_job_id INTEGER REFERENCES import_jobs(id) ON DELETE SET NULL;
+ALTER TABLE calories_data ADD COLUMN IF NOT EXISTS import_job_id INTEGER REFERENCES import_jobs(id) ON DELETE SET NULL;
+
+-- Imports check for existing samples at the same time before inserting
+CREATE INDEX IF NOT EXISTS idx_heart_rate_data_user_timestamp ON heart_rate_data (user_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_steps_data_user_timestamp ON steps_data (user_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_calories_data_user_timestamp ON calories_data (user_id, timestamp);
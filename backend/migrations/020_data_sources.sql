@@ -0,0 +1,27 @@
+-- Where each health record came from: a device, manual entry, an import
+-- (named by its kind or format) or an estimate
+
+ALTER TABLE health_data ADD COLUMN IF NOT EXISTS source VARCHAR(30) NOT NULL DEFAULT 'device';
+ALTER TABLE heart_rate_data ADD COLUMN IF NOT EXISTS source VARCHAR(30) NOT NULL DEFAULT 'device';
+ALTER TABLE steps_data ADD COLUMN IF NOT EXISTS source VARCHAR(30) NOT NULL DEFAULT 'device';
+ALTER TABLE calories_data ADD COLUMN IF NOT EXISTS source VARCHAR(30) NOT NULL DEFAULT 'device';
+ALTER TABLE activity_status_updates ADD COLUMN IF NOT EXISTS source VARCHAR(30) NOT NULL DEFAULT 'device';
+
+-- Existing rows: records without a device were entered manually, imported
+-- samples take their import's kind and estimates are marked as such.
+-- Samples posted as FHIR or Open mHealth had no import job or device either
+-- and cannot be told apart from manual entries, so those are unknown.
+UPDATE health_data SET source = 'manual' WHERE device_id IS NULL;
+UPDATE activity_status_updates SET source = 'manual';
+
+UPDATE heart_rate_data t SET source = CASE WHEN t.import_job_id IS NOT NULL
+    THEN (SELECT kind FROM import_jobs j WHERE j.id = t.import_job_id) ELSE 'unknown' END
+    WHERE t.device_id IS NULL OR t.import_job_id IS NOT NULL;
+UPDATE steps_data t SET source = CASE WHEN t.import_job_id IS NOT NULL
+    THEN (SELECT kind FROM import_jobs j WHERE j.id = t.import_job_id) ELSE 'unknown' END
+    WHERE t.device_id IS NULL OR t.import_job_id IS NOT NULL;
+UPDATE calories_data t SET source = CASE
+    WHEN t.is_estimated THEN 'estimated'
+    WHEN t.import_job_id IS NOT NULL THEN (SELECT kind FROM import_jobs j WHERE j.id = t.import_job_id)
+    ELSE 'unknown' END
+    WHERE t.device_id IS NULL OR t.import_job_id IS NOT NULL OR t.is_estimated;
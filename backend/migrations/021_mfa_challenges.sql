@@ -0,0 +1,13 @@
+-- Server-side state of MFA login challenges, so each can be tried a limited
+-- number of times and used only once
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    jti          VARCHAR(64) PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(user_id),
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    expires_at   TIMESTAMP NOT NULL,
+    consumed_at  TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user ON mfa_challenges (user_id);
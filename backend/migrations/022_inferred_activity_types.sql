@@ -0,0 +1,6 @@
+-- Marks samples whose activity type was inherited from the workout they were
+-- linked to, so discarding or relabelling the workout leaves types the
+-- device reported untouched
+
+ALTER TABLE heart_rate_data ADD COLUMN IF NOT EXISTS activity_type_inferred BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE calories_data ADD COLUMN IF NOT EXISTS activity_type_inferred BOOLEAN NOT NULL DEFAULT FALSE;
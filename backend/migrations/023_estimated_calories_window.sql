@@ -0,0 +1,11 @@
+-- At most one estimate per user and window, so concurrent estimations
+-- cannot fill the same window twice
+
+DELETE FROM calories_data a
+    USING calories_data b
+    WHERE a.is_estimated AND b.is_estimated
+      AND a.user_id = b.user_id AND a.timestamp = b.timestamp
+      AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_calories_data_estimated_window
+    ON calories_data (user_id, timestamp) WHERE is_estimated;
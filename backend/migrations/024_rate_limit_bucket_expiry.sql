@@ -0,0 +1,6 @@
+-- When each bucket will have refilled, after which it is equivalent to a
+-- missing one and can be deleted
+
+ALTER TABLE rate_limit_buckets ADD COLUMN IF NOT EXISTS full_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_full ON rate_limit_buckets (full_at);
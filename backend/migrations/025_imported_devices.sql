@@ -0,0 +1,15 @@
+-- Devices that recorded imported activity files, named after the file's
+-- creator. Samples imported from those files refer to them through their own
+-- column, since device_id holds the client's device numbers.
+
+CREATE TABLE IF NOT EXISTS imported_devices (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL REFERENCES users(user_id),
+    name        VARCHAR(100) NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
+
+ALTER TABLE heart_rate_data ADD COLUMN IF NOT EXISTS imported_device_id INTEGER REFERENCES imported_devices(id);
+ALTER TABLE steps_data ADD COLUMN IF NOT EXISTS imported_device_id INTEGER REFERENCES imported_devices(id);
+ALTER TABLE calories_data ADD COLUMN IF NOT EXISTS imported_device_id INTEGER REFERENCES imported_devices(id);
@@ -0,0 +1,4 @@
+-- Hash of the nonce in the emailed cancellation link, so the link only
+-- cancels the request it was sent for
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_cancel_hash VARCHAR(64);
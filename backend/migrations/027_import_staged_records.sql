@@ -0,0 +1,13 @@
+-- Steps and energy records of a running Apple Health import, held until the
+-- whole export has been read and one source can be chosen for each hour
+
+CREATE TABLE IF NOT EXISTS import_staged_records (
+    import_job_id  INTEGER NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    metric         VARCHAR(20) NOT NULL,
+    timestamp      TIMESTAMP NOT NULL,
+    value          DOUBLE PRECISION NOT NULL,
+    source         VARCHAR(255) NOT NULL,
+    rank           INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_staged_records_job ON import_staged_records (import_job_id);
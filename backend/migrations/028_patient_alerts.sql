@@ -0,0 +1,17 @@
+-- Heart rate threshold breaches of enrolled patients, open until a clinician
+-- acknowledges them
+
+CREATE TABLE IF NOT EXISTS patient_alerts (
+    id               SERIAL PRIMARY KEY,
+    user_id          INTEGER NOT NULL REFERENCES users(user_id),
+    kind             VARCHAR(40) NOT NULL,
+    value            INTEGER NOT NULL,
+    triggered_at     TIMESTAMP NOT NULL,
+    acknowledged_at  TIMESTAMP,
+    acknowledged_by  INTEGER REFERENCES users(user_id),
+    created_at       TIMESTAMP DEFAULT NOW()
+);
+
+-- A patient has at most one open alert of each kind
+CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_alerts_open
+    ON patient_alerts (user_id, kind) WHERE acknowledged_at IS NULL;
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// UserFilters represents query parameters for searching users
+type UserFilters struct {
+	Query  string `form:"q"`
+	Role   string `form:"role"`
+	Active *bool  `form:"active"`
+	Limit  int    `form:"limit,default=50"`
+	Offset int    `form:"offset,default=0"`
+}
+
+// UpdateRoleRequest defines the request body for changing a user's role
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user caregiver clinician admin"`
+}
+
+// UserStats counts accounts by state
+type UserStats struct {
+	Total        int            `json:"total"`
+	Active       int            `json:"active"`
+	Verified     int            `json:"verified"`
+	MFAEnabled   int            `json:"mfa_enabled"`
+	NewLast7Days int            `json:"new_last_7_days"`
+	ByRole       map[string]int `json:"by_role"`
+}
+
+// SystemStats is an overview of the system for administrators
+type SystemStats struct {
+	Users          UserStats        `json:"users"`
+	ActiveSessions int              `json:"active_sessions"`
+	Workouts       int              `json:"workouts"`
+	Samples        map[string]int64 `json:"samples"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+}
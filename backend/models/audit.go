@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// AuditEntry represents the audit_log table
+type AuditEntry struct {
+	ID            int64     `json:"id"`
+	ActorID       *int      `json:"actor_id"`
+	SubjectUserID *int      `json:"subject_user_id"`
+	Action        string    `json:"action"`
+	Resource      string    `json:"resource"`
+	Method        string    `json:"method"`
+	StatusCode    int       `json:"status_code"`
+	IPAddress     string    `json:"ip_address"`
+	CreatedAt     time.Time `json:"created_at"`
+	PrevHash      string    `json:"prev_hash"`
+	Hash          string    `json:"hash"`
+}
+
+// AuditFilters represents query parameters for searching the audit log
+type AuditFilters struct {
+	UserID    int    `form:"user_id"` // Admins only: entries where the user is actor or subject
+	Action    string `form:"action"`
+	StartDate string `form:"start_date"`
+	EndDate   string `form:"end_date"`
+	Limit     int    `form:"limit,default=50"`
+	Offset    int    `form:"offset,default=0"`
+}
+
+// AuditVerification is the result of checking the audit log hash chain
+type AuditVerification struct {
+	Valid          bool   `json:"valid"`
+	EntriesChecked int    `json:"entries_checked"`
+	BrokenAtID     *int64 `json:"broken_at_id,omitempty"`
+}
@@ -0,0 +1,110 @@
+package models
+
+// FHIR R4 resources, limited to the elements needed to exchange heart rate,
+// steps and calories observations
+
+// FHIRCoding is a code from a terminology system
+type FHIRCoding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// FHIRCodeableConcept is a set of codings with optional text
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+// FHIRQuantity is a measured amount with a UCUM unit
+type FHIRQuantity struct {
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	System string   `json:"system,omitempty"`
+	Code   string   `json:"code,omitempty"`
+}
+
+// FHIRReference points to another resource, e.g. "Patient/12"
+type FHIRReference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+// FHIRPeriod is a time range; either end may be open
+type FHIRPeriod struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// FHIRObservation is an R4 Observation resource
+type FHIRObservation struct {
+	ResourceType      string                `json:"resourceType"`
+	ID                string                `json:"id,omitempty"`
+	Status            string                `json:"status"`
+	Category          []FHIRCodeableConcept `json:"category,omitempty"`
+	Code              FHIRCodeableConcept   `json:"code"`
+	Subject           *FHIRReference        `json:"subject,omitempty"`
+	EffectiveDateTime string                `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod   *FHIRPeriod           `json:"effectivePeriod,omitempty"`
+	Issued            string                `json:"issued,omitempty"`
+	ValueQuantity     *FHIRQuantity         `json:"valueQuantity,omitempty"`
+	Device            *FHIRReference        `json:"device,omitempty"`
+}
+
+// FHIRBundleLink is a navigation link of a search result bundle
+type FHIRBundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+// FHIRBundleSearch tells why an entry is in a search result
+type FHIRBundleSearch struct {
+	Mode string `json:"mode"`
+}
+
+// FHIRBundleResponse is the outcome of one entry of a batch or transaction
+type FHIRBundleResponse struct {
+	Status   string                `json:"status"`
+	Location string                `json:"location,omitempty"`
+	Outcome  *FHIROperationOutcome `json:"outcome,omitempty"`
+}
+
+// FHIRBundleEntry is one resource in a bundle
+type FHIRBundleEntry struct {
+	FullURL  string              `json:"fullUrl,omitempty"`
+	Resource *FHIRObservation    `json:"resource,omitempty"`
+	Search   *FHIRBundleSearch   `json:"search,omitempty"`
+	Response *FHIRBundleResponse `json:"response,omitempty"`
+}
+
+// FHIRBundle is an R4 Bundle resource
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	Type         string            `json:"type"`
+	Total        *int              `json:"total,omitempty"`
+	Link         []FHIRBundleLink  `json:"link,omitempty"`
+	Entry        []FHIRBundleEntry `json:"entry,omitempty"`
+}
+
+// FHIROperationOutcomeIssue describes one error or warning
+type FHIROperationOutcomeIssue struct {
+	Severity    string `json:"severity"`
+	Code        string `json:"code"`
+	Diagnostics string `json:"diagnostics,omitempty"`
+}
+
+// FHIROperationOutcome reports errors in FHIR form
+type FHIROperationOutcome struct {
+	ResourceType string                      `json:"resourceType"`
+	Issue        []FHIROperationOutcomeIssue `json:"issue"`
+}
+
+// FHIRObservationSearch holds the supported Observation search parameters
+type FHIRObservationSearch struct {
+	// Patient is "Patient/<id>" or a bare user ID; empty means the caller
+	Patient string   `form:"patient"`
+	Code    string   `form:"code"`
+	Date    []string `form:"date"`
+	Count   int      `form:"_count"`
+	Offset  int      `form:"_offset"`
+}
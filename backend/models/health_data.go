@@ -2,6 +2,25 @@ package models
 
 import "time"
 
+// Sources of health records. Imported samples use their import's kind, or
+// the format they were posted in. Samples recorded before sources were
+// tracked whose origin cannot be told are unknown.
+const (
+	SourceDevice    = "device"
+	SourceManual    = "manual"
+	SourceEstimated = "estimated"
+	SourceFHIR      = "fhir"
+	SourceOMH       = "omh"
+	SourceUnknown   = "unknown"
+)
+
+// SourcePriority orders sources from most to least trusted. Where sources
+// overlap, summaries count only the highest-ranked one.
+var SourcePriority = []string{
+	SourceDevice, ImportKindActivityFile, ImportKindAppleHealth, ImportKindGoogleFit,
+	SourceFHIR, SourceOMH, SourceManual, SourceEstimated, SourceUnknown,
+}
+
 // HealthData represents the main health data for dashboard display
 type HealthData struct {
 	DataID             int       `json:"data_id"`
@@ -13,6 +32,7 @@ type HealthData struct {
 	CaloriesBurned     *int      `json:"calories_burned"`
 	ActivityStatus     string    `json:"activity_status"`
 	ActivityGaugeValue float64   `json:"activity_gauge_value"`
+	Source             string    `json:"source"`
 	CreatedAt          time.Time `json:"created_at"`
 }
 
@@ -24,6 +44,8 @@ type HeartRateData struct {
 	Timestamp    time.Time `json:"timestamp"`
 	HeartRate    int       `json:"heart_rate"`
 	ActivityType *string   `json:"activity_type"`
+	WorkoutID    *int      `json:"workout_id"`
+	Source       string    `json:"source"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -35,7 +57,12 @@ type StepsData struct {
 	Timestamp  time.Time `json:"timestamp"`
 	StepsCount int       `json:"steps_count"`
 	Distance   *float64  `json:"distance"`
+	WorkoutID  *int      `json:"workout_id"`
+	Source     string    `json:"source"`
 	CreatedAt  time.Time `json:"created_at"`
+
+	// DistanceEstimated is set when Distance was derived from the step count
+	DistanceEstimated bool `json:"distance_estimated"`
 }
 
 // CaloriesData represents calories history
@@ -46,6 +73,9 @@ type CaloriesData struct {
 	Timestamp      time.Time `json:"timestamp"`
 	CaloriesBurned int       `json:"calories_burned"`
 	ActivityType   *string   `json:"activity_type"`
+	WorkoutID      *int      `json:"workout_id"`
+	IsEstimated    bool      `json:"is_estimated"`
+	Source         string    `json:"source"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
@@ -57,6 +87,7 @@ type ActivityStatusUpdate struct {
 	PreviousStatus     *string   `json:"previous_status"`
 	CurrentStatus      string    `json:"current_status"`
 	StatusChangeReason *string   `json:"status_change_reason"`
+	Source             string    `json:"source"`
 	CreatedAt          time.Time `json:"created_at"`
 }
 
@@ -68,6 +99,7 @@ type HealthDataRequest struct {
 	CaloriesBurned     *int    `json:"calories_burned"`
 	ActivityStatus     string  `json:"activity_status" binding:"required"`
 	ActivityGaugeValue float64 `json:"activity_gauge_value" binding:"required"`
+	Source             string  `json:"source" binding:"omitempty,oneof=device manual"`
 }
 
 // HeartRateRequest is used for creating heart rate data
@@ -75,6 +107,7 @@ type HeartRateRequest struct {
 	DeviceID     *int    `json:"device_id"`
 	HeartRate    int     `json:"heart_rate" binding:"required"`
 	ActivityType *string `json:"activity_type"`
+	Source       string  `json:"source" binding:"omitempty,oneof=device manual"`
 }
 
 // StepsRequest is used for creating steps data
@@ -82,6 +115,7 @@ type StepsRequest struct {
 	DeviceID   *int     `json:"device_id"`
 	StepsCount int      `json:"steps_count" binding:"required"`
 	Distance   *float64 `json:"distance"`
+	Source     string   `json:"source" binding:"omitempty,oneof=device manual"`
 }
 
 // CaloriesRequest is used for creating calories data
@@ -89,6 +123,7 @@ type CaloriesRequest struct {
 	DeviceID       *int    `json:"device_id"`
 	CaloriesBurned int     `json:"calories_burned" binding:"required"`
 	ActivityType   *string `json:"activity_type"`
+	Source         string  `json:"source" binding:"omitempty,oneof=device manual"`
 }
 
 // ActivityStatusRequest is used for creating activity status updates
@@ -96,6 +131,13 @@ type ActivityStatusRequest struct {
 	PreviousStatus     *string `json:"previous_status"`
 	CurrentStatus      string  `json:"current_status" binding:"required"`
 	StatusChangeReason *string `json:"status_change_reason"`
+	Source             string  `json:"source" binding:"omitempty,oneof=device manual"`
+}
+
+// CaloriesEstimateRequest is used for estimating calories over a time range
+type CaloriesEstimateRequest struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
 }
 
 // HealthDataFilters represents query parameters for filtering health data
@@ -104,12 +146,16 @@ type HealthDataFilters struct {
 	EndDate   string `form:"end_date"`
 	Limit     int    `form:"limit,default=30"`
 	Offset    int    `form:"offset,default=0"`
+
+	// Source limits results to a comma-separated list of sources
+	Source string `form:"source"`
 }
 
 // HealthDataSummary represents summary statistics for health data
 type HealthDataSummary struct {
 	AverageHeartRate     float64        `json:"average_heart_rate"`
 	TotalSteps           int            `json:"total_steps"`
+	TotalDistance        float64        `json:"total_distance"`
 	TotalCaloriesBurned  int            `json:"total_calories_burned"`
 	ActivityDistribution map[string]int `json:"activity_distribution"`
 	PeriodStart          time.Time      `json:"period_start"`
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Kinds of files that can be imported
+const (
+	ImportKindAppleHealth  = "apple_health"
+	ImportKindActivityFile = "activity_file"
+	ImportKindGoogleFit    = "google_fit"
+)
+
+// Import job states
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// ImportJob represents the import_jobs table
+type ImportJob struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Kind            string     `json:"kind"`
+	Status          string     `json:"status"`
+	FileName        string     `json:"file_name"`
+	BytesTotal      int64      `json:"bytes_total"`
+	BytesProcessed  int64      `json:"bytes_processed"`
+	Progress        float64    `json:"progress"`
+	RecordsFound    int        `json:"records_found"`
+	RecordsImported int        `json:"records_imported"`
+	RecordsSkipped  int        `json:"records_skipped"`
+	Error           string     `json:"error,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       *time.Time `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	FilePath        string     `json:"-"`
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Open mHealth schemas exchanged by the API
+const (
+	OMHSchemaHeartRate        = "heart-rate"
+	OMHSchemaStepCount        = "step-count"
+	OMHSchemaCaloriesBurned   = "calories-burned"
+	OMHSchemaPhysicalActivity = "physical-activity"
+)
+
+// OMHSchemaID identifies the schema of a data point's body
+type OMHSchemaID struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+}
+
+// OMHAcquisitionProvenance tells where a data point came from
+type OMHAcquisitionProvenance struct {
+	SourceName string `json:"source_name"`
+	Modality   string `json:"modality,omitempty"`
+}
+
+// OMHHeader is the header of an Open mHealth data point
+type OMHHeader struct {
+	ID                    string                    `json:"id"`
+	CreationDateTime      time.Time                 `json:"creation_date_time"`
+	SchemaID              OMHSchemaID               `json:"schema_id"`
+	AcquisitionProvenance *OMHAcquisitionProvenance `json:"acquisition_provenance,omitempty"`
+	UserID                string                    `json:"user_id,omitempty"`
+}
+
+// OMHDataPoint is an Open mHealth data point. Body is one of the OMH body
+// types below when serializing; incoming bodies are decoded by schema.
+type OMHDataPoint struct {
+	Header OMHHeader   `json:"header"`
+	Body   interface{} `json:"body"`
+}
+
+// OMHUnitValue is a value with a unit. Older schema versions give some
+// values, such as step_count, as a bare number, which is also accepted.
+type OMHUnitValue struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// UnmarshalJSON accepts either {"value": ..., "unit": ...} or a bare number
+func (v *OMHUnitValue) UnmarshalJSON(data []byte) error {
+	var number float64
+	if err := json.Unmarshal(data, &number); err == nil {
+		v.Value = number
+		return nil
+	}
+
+	type unitValue OMHUnitValue
+	return json.Unmarshal(data, (*unitValue)(v))
+}
+
+// OMHTimeInterval is a time range given by its bounds or a start and duration
+type OMHTimeInterval struct {
+	StartDateTime *time.Time    `json:"start_date_time,omitempty"`
+	EndDateTime   *time.Time    `json:"end_date_time,omitempty"`
+	Duration      *OMHUnitValue `json:"duration,omitempty"`
+}
+
+// OMHTimeFrame is either a point in time or a time interval
+type OMHTimeFrame struct {
+	DateTime     *time.Time       `json:"date_time,omitempty"`
+	TimeInterval *OMHTimeInterval `json:"time_interval,omitempty"`
+}
+
+// OMHHeartRate is the body of a heart-rate data point
+type OMHHeartRate struct {
+	HeartRate          OMHUnitValue `json:"heart_rate"`
+	EffectiveTimeFrame OMHTimeFrame `json:"effective_time_frame"`
+}
+
+// OMHStepCount is the body of a step-count data point
+type OMHStepCount struct {
+	StepCount          OMHUnitValue `json:"step_count"`
+	EffectiveTimeFrame OMHTimeFrame `json:"effective_time_frame"`
+}
+
+// OMHCaloriesBurned is the body of a calories-burned data point
+type OMHCaloriesBurned struct {
+	KcalBurned         OMHUnitValue `json:"kcal_burned"`
+	ActivityName       string       `json:"activity_name,omitempty"`
+	EffectiveTimeFrame OMHTimeFrame `json:"effective_time_frame"`
+}
+
+// OMHPhysicalActivity is the body of a physical-activity data point
+type OMHPhysicalActivity struct {
+	ActivityName       string        `json:"activity_name"`
+	EffectiveTimeFrame OMHTimeFrame  `json:"effective_time_frame"`
+	Distance           *OMHUnitValue `json:"distance,omitempty"`
+	KcalBurned         *OMHUnitValue `json:"kcal_burned,omitempty"`
+}
+
+// OMHImportError describes a data point that could not be imported
+type OMHImportError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// OMHImportResult summarizes an Open mHealth ingestion request
+type OMHImportResult struct {
+	Created int              `json:"created"`
+	Skipped int              `json:"skipped"`
+	Errors  []OMHImportError `json:"errors"`
+}
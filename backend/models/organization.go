@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+// Roles of members within an organization
+const (
+	OrgRoleAdmin     = "admin"
+	OrgRoleClinician = "clinician"
+)
+
+// Patient enrollment states
+const (
+	EnrollmentStatusPending = "pending"
+	EnrollmentStatusActive  = "active"
+	EnrollmentStatusRevoked = "revoked"
+)
+
+// Organization represents the organizations table
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy *int      `json:"created_by"` // Nil once the creator's account is deleted
+	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role,omitempty"` // The requesting user's role in it
+}
+
+// OrgMember represents the organization_members table
+type OrgMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// PatientEnrollment represents the patient_enrollments table
+type PatientEnrollment struct {
+	ID              int        `json:"id"`
+	OrgID           int        `json:"org_id"`
+	OrgName         string     `json:"org_name"`
+	PatientID       int        `json:"patient_id"`
+	PatientUsername string     `json:"patient_username"`
+	InvitedBy       *int       `json:"invited_by"`
+	Status          string     `json:"status"`
+	ConsentedAt     *time.Time `json:"consented_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// Kinds of patient alerts
+const (
+	AlertKindHighHeartRate = "high_heart_rate"
+	AlertKindLowHeartRate  = "low_heart_rate"
+)
+
+// PatientAlert represents the patient_alerts table: a threshold breach in a
+// patient's samples, open until a clinician acknowledges it
+type PatientAlert struct {
+	ID             int        `json:"id"`
+	PatientID      int        `json:"patient_id"`
+	Kind           string     `json:"kind"`
+	Value          int        `json:"value"`
+	TriggeredAt    time.Time  `json:"triggered_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	AcknowledgedBy *int       `json:"acknowledged_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// PatientPanelEntry summarizes one enrolled patient for clinicians.
+// HeartRate is the most recent from any source.
+type PatientPanelEntry struct {
+	PatientID   int            `json:"patient_id"`
+	Username    string         `json:"username"`
+	Email       string         `json:"email"`
+	EnrolledAt  time.Time      `json:"enrolled_at"`
+	Latest      *HealthData    `json:"latest"`
+	HeartRate   *int           `json:"heart_rate"`
+	LastSeen    *time.Time     `json:"last_seen"`
+	RiskScore   int            `json:"risk_score"`
+	RiskFactors []string       `json:"risk_factors"`
+	OpenAlerts  []PatientAlert `json:"open_alerts"`
+}
+
+// CreateOrganizationRequest defines the request body for creating an organization
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// AddOrgMemberRequest defines the request body for adding staff to an organization
+type AddOrgMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=admin clinician"`
+}
+
+// EnrollPatientRequest defines the request body for inviting a patient
+type EnrollPatientRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// PatientPanelFilters represents query parameters for the patient panel
+type PatientPanelFilters struct {
+	Sort string `form:"sort" binding:"omitempty,oneof=risk last_seen name"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Metrics that can be shared with another account
+const (
+	ShareMetricHeartRate = "heart_rate"
+	ShareMetricSteps     = "steps"
+	ShareMetricCalories  = "calories"
+	ShareMetricActivity  = "activity"
+)
+
+// Share grant states, derived from the grant's timestamps
+const (
+	ShareStatusPending = "pending"
+	ShareStatusActive  = "active"
+	ShareStatusExpired = "expired"
+	ShareStatusRevoked = "revoked"
+)
+
+// ShareGrant represents the share_grants table
+type ShareGrant struct {
+	ID            int        `json:"id"`
+	OwnerID       int        `json:"owner_id"`
+	OwnerUsername string     `json:"owner_username"`
+	GranteeEmail  string     `json:"grantee_email"`
+	GranteeID     *int       `json:"grantee_id"`
+	Metrics       []string   `json:"metrics"`
+	Status        string     `json:"status"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	AcceptedAt    *time.Time `json:"accepted_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// CreateShareGrantRequest defines the request body for inviting another
+// account to read the user's data
+type CreateShareGrantRequest struct {
+	Email     string     `json:"email" binding:"required,email"`
+	Metrics   []string   `json:"metrics" binding:"required,min=1,dive,oneof=heart_rate steps calories activity"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
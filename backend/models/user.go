@@ -4,6 +4,14 @@ import (
 	"time"
 )
 
+// User roles
+const (
+	RoleUser      = "user"
+	RoleCaregiver = "caregiver"
+	RoleClinician = "clinician"
+	RoleAdmin     = "admin"
+)
+
 // User represents the users table
 type User struct {
 	UserID    int       `json:"user_id"`
@@ -13,35 +21,154 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	LastLogin time.Time `json:"last_login,omitempty"`
 	IsActive  bool      `json:"is_active"`
+	Role      string    `json:"role"`
+
+	EmailVerified bool `json:"email_verified"`
+	MFAEnabled    bool `json:"mfa_enabled"`
+
+	// Profile fields used for energy expenditure and distance estimation
+	DateOfBirth     *time.Time `json:"date_of_birth"`
+	WeightKg        *float64   `json:"weight_kg"`
+	Sex             *string    `json:"sex"`
+	HeightCm        *float64   `json:"height_cm"`
+	WalkingStrideCm *float64   `json:"walking_stride_cm"`
+	RunningStrideCm *float64   `json:"running_stride_cm"`
 }
 
 // Session represents the sessions table
 type Session struct {
-	SessionID int       `json:"session_id"`
-	UserID    int       `json:"user_id"`
-	Token     string    `json:"token"`
-	IPAddress string    `json:"ip_address"`
-	IssuedAt  time.Time `json:"issued_at"`
-	ExpiresAt time.Time `json:"expires_at"`
-	IsValid   bool      `json:"is_valid"`
+	SessionID        int        `json:"session_id"`
+	UserID           int        `json:"user_id"`
+	Token            string     `json:"-"` // Tokens are never returned in JSON
+	IPAddress        string     `json:"ip_address"`
+	UserAgent        string     `json:"user_agent"`
+	DeviceName       string     `json:"device_name"`
+	IssuedAt         time.Time  `json:"issued_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RefreshExpiresAt *time.Time `json:"refresh_expires_at"`
+	IsValid          bool       `json:"is_valid"`
+	Current          bool       `json:"current"`
+}
+
+// SessionMetadata describes the client a session was created from
+type SessionMetadata struct {
+	IPAddress  string
+	UserAgent  string
+	DeviceName string
+}
+
+// TokenClaims holds the identity carried by a validated access token
+type TokenClaims struct {
+	UserID int
+	JTI    string
 }
 
 // LoginRequest defines the login request body
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=6"`
+	DeviceName string `json:"device_name" binding:"omitempty,max=100"`
 }
 
 // RegisterRequest defines the registration request body
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required,min=3,max=50"`
-	Email    string `json:"email" binding:"required,email"`
+	Username   string `json:"username" binding:"required,min=3,max=50"`
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=6"`
+	DeviceName string `json:"device_name" binding:"omitempty,max=100"`
+}
+
+// UpdateProfileRequest defines the profile update request body
+type UpdateProfileRequest struct {
+	DateOfBirth *string  `json:"date_of_birth" binding:"omitempty,datetime=2006-01-02"`
+	WeightKg    *float64 `json:"weight_kg" binding:"omitempty,gt=0,lt=500"`
+	Sex         *string  `json:"sex" binding:"omitempty,oneof=male female"`
+	HeightCm    *float64 `json:"height_cm" binding:"omitempty,gt=0,lt=300"`
+
+	// Stride overrides; derived from height when not set
+	WalkingStrideCm *float64 `json:"walking_stride_cm" binding:"omitempty,gt=0,lt=300"`
+	RunningStrideCm *float64 `json:"running_stride_cm" binding:"omitempty,gt=0,lt=300"`
+}
+
+// DeleteAccountRequest defines the request body for deleting the user's account
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// CancelDeletionRequest defines the request body for cancelling an account deletion
+type CancelDeletionRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ForgotPasswordRequest defines the password reset request body
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResendVerificationRequest defines the body for resending the verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest defines the body for setting a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
 	Password string `json:"password" binding:"required,min=6"`
 }
 
+// RefreshRequest defines the token refresh request body
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	DeviceName   string `json:"device_name" binding:"omitempty,max=100"`
+}
+
+// SessionTokens holds the access and refresh tokens issued for a session
+type SessionTokens struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// LoginResult is the outcome of a password check: either session tokens,
+// or an MFA challenge when the account has two-factor authentication
+type LoginResult struct {
+	User         *User
+	Tokens       *SessionTokens
+	MFAChallenge *MFAChallengeResponse
+}
+
+// MFAChallengeResponse is returned by login when a second factor is required
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// MFALoginRequest defines the body for completing a login with a second factor
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+	DeviceName   string `json:"device_name" binding:"omitempty,max=100"`
+}
+
+// MFACodeRequest defines a request body carrying a TOTP or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAEnrollment is returned when starting two-factor enrollment
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
 // AuthResponse defines the response for authentication endpoints
 type AuthResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt string `json:"expires_at"`
-	User      User   `json:"user"`
+	Token            string `json:"token"`
+	ExpiresAt        string `json:"expires_at"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresAt string `json:"refresh_expires_at,omitempty"`
+	User             User   `json:"user"`
 }
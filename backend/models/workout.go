@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Workout statuses
+const (
+	WorkoutStatusActive    = "active"
+	WorkoutStatusCompleted = "completed"
+	WorkoutStatusTentative = "tentative"
+	WorkoutStatusDiscarded = "discarded"
+)
+
+// HeartRateZones holds the number of seconds spent in each heart rate zone
+type HeartRateZones struct {
+	Zone1 int `json:"zone_1"` // 50-60% of max heart rate
+	Zone2 int `json:"zone_2"` // 60-70% of max heart rate
+	Zone3 int `json:"zone_3"` // 70-80% of max heart rate
+	Zone4 int `json:"zone_4"` // 80-90% of max heart rate
+	Zone5 int `json:"zone_5"` // 90%+ of max heart rate
+}
+
+// Workout represents a recorded workout session and its computed metrics
+type Workout struct {
+	WorkoutID       int            `json:"workout_id"`
+	UserID          int            `json:"user_id"`
+	DeviceID        *int           `json:"device_id"`
+	WorkoutType     string         `json:"workout_type"`
+	Status          string         `json:"status"`
+	Detected        bool           `json:"detected"`
+	StartTime       time.Time      `json:"start_time"`
+	EndTime         *time.Time     `json:"end_time"`
+	DurationSeconds int            `json:"duration_seconds"`
+	AvgHeartRate    *float64       `json:"avg_heart_rate"`
+	MaxHeartRate    *int           `json:"max_heart_rate"`
+	TotalSteps      int            `json:"total_steps"`
+	Distance        float64        `json:"distance"`
+	CaloriesBurned  int            `json:"calories_burned"`
+	HeartRateZones  HeartRateZones `json:"heart_rate_zones"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+// StartWorkoutRequest is used for starting a workout session
+type StartWorkoutRequest struct {
+	DeviceID    *int   `json:"device_id"`
+	WorkoutType string `json:"workout_type" binding:"required,max=50"`
+}
+
+// UpdateWorkoutRequest is used for relabeling a workout
+type UpdateWorkoutRequest struct {
+	WorkoutType string `json:"workout_type" binding:"required,max=50"`
+}
+
+// WorkoutFilters represents query parameters for filtering workouts
+type WorkoutFilters struct {
+	WorkoutType string `form:"workout_type"`
+	Status      string `form:"status"`
+	StartDate   string `form:"start_date"`
+	EndDate     string `form:"end_date"`
+	Limit       int    `form:"limit,default=30"`
+	Offset      int    `form:"offset,default=0"`
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// SetupAdminRoutes configures routes restricted to administrators
+func SetupAdminRoutes(router *gin.Engine) {
+	admin := router.Group("/api/admin")
+	admin.Use(middleware.AuthMiddleware(), middleware.RequireRole(models.RoleAdmin), middleware.RateLimit("read"))
+	{
+		admin.GET("/stats", controllers.GetSystemStats)
+		admin.GET("/audit/verify", controllers.VerifyAuditLog)
+
+		// User management
+		admin.GET("/users", controllers.ListUsers)
+		admin.GET("/users/:id", controllers.GetUser)
+		admin.PUT("/users/:id/role", controllers.UpdateUserRole)
+		admin.POST("/users/:id/deactivate", controllers.DeactivateUser)
+		admin.POST("/users/:id/activate", controllers.ActivateUser)
+		admin.POST("/users/:id/unlock", controllers.UnlockUser)
+	}
+}
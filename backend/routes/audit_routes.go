@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+)
+
+// SetupAuditRoutes configures the audit log routes
+func SetupAuditRoutes(router *gin.Engine) {
+	audit := router.Group("/api/audit")
+	audit.Use(middleware.AuthMiddleware(), middleware.RateLimit("read"))
+	{
+		audit.GET("", controllers.GetAuditLog)
+	}
+}
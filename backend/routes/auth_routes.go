@@ -11,16 +11,41 @@ import (
 func SetupAuthRoutes(router *gin.Engine) {
 	// Public routes (no authentication required)
 	auth := router.Group("/api/auth")
+	auth.Use(middleware.AuditLog("auth"), middleware.RateLimit("auth"))
 	{
 		auth.POST("/register", controllers.Register)
 		auth.POST("/login", controllers.Login)
+		auth.POST("/login/mfa", controllers.LoginMFA)
+		auth.POST("/refresh", controllers.Refresh)
+
+		// Password recovery
+		auth.POST("/password/forgot", controllers.ForgotPassword)
+		auth.POST("/password/reset", controllers.ResetPassword)
+
+		// Email verification
+		auth.GET("/email/verify", controllers.VerifyEmail)
+		auth.POST("/email/resend", controllers.ResendVerification)
+
+		// Account deletion grace period
+		auth.POST("/account/cancel-deletion", controllers.CancelAccountDeletion)
 	}
 
 	// Protected routes (authentication required)
 	protected := router.Group("/api/auth")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuditLog("auth"), middleware.AuthMiddleware(), middleware.RateLimit("auth"))
 	{
 		protected.POST("/logout", controllers.Logout)
 		protected.GET("/me", controllers.Me)
+
+		// Session management
+		protected.GET("/sessions", controllers.GetSessions)
+		protected.DELETE("/sessions", controllers.RevokeAllSessions)
+		protected.DELETE("/sessions/:id", controllers.RevokeSession)
+
+		// Two-factor authentication
+		protected.POST("/mfa/enroll", controllers.EnrollMFA)
+		protected.POST("/mfa/confirm", controllers.ConfirmMFA)
+		protected.POST("/mfa/disable", controllers.DisableMFA)
+		protected.POST("/mfa/recovery-codes", controllers.RegenerateRecoveryCodes)
 	}
 }
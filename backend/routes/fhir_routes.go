@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+)
+
+// SetupFHIRRoutes configures the HL7 FHIR R4 endpoints used by partner clinics
+func SetupFHIRRoutes(router *gin.Engine) {
+	fhir := router.Group("/fhir")
+	fhir.Use(middleware.AuditLog("health"), middleware.AuthMiddleware())
+	{
+		fhir.GET("/Observation", middleware.RateLimit("read"), controllers.SearchFHIRObservations)
+		fhir.POST("/Observation", middleware.RateLimit("ingestion"), middleware.RequireVerifiedEmail(), controllers.ImportFHIRObservations)
+	}
+}
@@ -11,40 +11,51 @@ import (
 func SetupHealthRoutes(router *gin.Engine) {
 	// All health data routes require authentication
 	health := router.Group("/api/health")
-	health.Use(middleware.AuthMiddleware())
+	health.Use(middleware.AuditLog("health"), middleware.AuthMiddleware())
+
+	// Recording data may require a verified email, depending on policy
+	verified := middleware.RequireVerifiedEmail()
+
+	// Reads and ingestion are rate limited separately
+	read := middleware.RateLimit("read")
+	ingestion := middleware.RateLimit("ingestion")
 	{
 		// Main health data endpoints
-		health.GET("/current", controllers.GetCurrentHealthData)
-		health.GET("/history", controllers.GetHealthDataHistory)
-		health.POST("/record", controllers.CreateHealthData)
-		health.GET("/summary", controllers.GetHealthDataSummary)
+		health.GET("/current", read, controllers.GetCurrentHealthData)
+		health.GET("/history", read, controllers.GetHealthDataHistory)
+		health.POST("/record", ingestion, verified, controllers.CreateHealthData)
+		health.GET("/summary", read, controllers.GetHealthDataSummary)
+
+		// Open mHealth data points for any supported metric
+		health.POST("/omh", ingestion, verified, controllers.IngestOMHDataPoints)
 
 		// Heart rate specific endpoints
 		heartRate := health.Group("/heart-rate")
 		{
-			heartRate.GET("/history", controllers.GetHeartRateHistory)
-			heartRate.POST("/record", controllers.CreateHeartRateData)
+			heartRate.GET("/history", read, controllers.GetHeartRateHistory)
+			heartRate.POST("/record", ingestion, verified, controllers.CreateHeartRateData)
 		}
 
 		// Steps specific endpoints
 		steps := health.Group("/steps")
 		{
-			steps.GET("/history", controllers.GetStepsHistory)
-			steps.POST("/record", controllers.CreateStepsData)
+			steps.GET("/history", read, controllers.GetStepsHistory)
+			steps.POST("/record", ingestion, verified, controllers.CreateStepsData)
 		}
 
 		// Calories specific endpoints
 		calories := health.Group("/calories")
 		{
-			calories.GET("/history", controllers.GetCaloriesHistory)
-			calories.POST("/record", controllers.CreateCaloriesData)
+			calories.GET("/history", read, controllers.GetCaloriesHistory)
+			calories.POST("/record", ingestion, verified, controllers.CreateCaloriesData)
+			calories.POST("/estimate", ingestion, verified, controllers.EstimateCalories)
 		}
 
 		// Activity status endpoints
 		activity := health.Group("/activity")
 		{
-			activity.GET("/history", controllers.GetActivityStatusHistory)
-			activity.POST("/status", controllers.CreateActivityStatusUpdate)
+			activity.GET("/history", read, controllers.GetActivityStatusHistory)
+			activity.POST("/status", ingestion, verified, controllers.CreateActivityStatusUpdate)
 		}
 	}
 }
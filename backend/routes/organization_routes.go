@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// SetupOrganizationRoutes configures organization, staff and patient panel routes
+func SetupOrganizationRoutes(router *gin.Engine) {
+	orgs := router.Group("/api/orgs")
+	orgs.Use(middleware.AuditLog("organization"), middleware.AuthMiddleware(), middleware.RateLimit("read"))
+	{
+		orgs.GET("", controllers.GetOrganizations)
+		orgs.POST("", middleware.RequireRole(models.RoleClinician, models.RoleAdmin), controllers.CreateOrganization)
+
+		member := middleware.RequireOrgMember()
+		orgAdmin := middleware.RequireOrgMember(models.OrgRoleAdmin)
+
+		orgs.GET("/:id", member, controllers.GetOrganization)
+
+		// Staff
+		orgs.GET("/:id/members", member, controllers.GetOrgMembers)
+		orgs.POST("/:id/members", orgAdmin, controllers.AddOrgMember)
+		orgs.DELETE("/:id/members/:userId", orgAdmin, controllers.RemoveOrgMember)
+
+		// Patients
+		orgs.GET("/:id/patients", member, controllers.GetPatientPanel)
+		orgs.POST("/:id/patients", member, controllers.EnrollPatient)
+		orgs.DELETE("/:id/patients/:patientId", member, controllers.RemovePatient)
+		orgs.POST("/:id/patients/:patientId/alerts/:alertId/acknowledge", member, controllers.AcknowledgePatientAlert)
+	}
+}
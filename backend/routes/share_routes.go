@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// SetupSharedDataRoutes configures read-only routes to another user's health
+// data, guarded by the grants that user has given
+func SetupSharedDataRoutes(router *gin.Engine) {
+	health := router.Group("/api/users/:id/health")
+	health.Use(middleware.AuditLog("health"), middleware.AuthMiddleware(), middleware.RateLimit("read"))
+	{
+		// Combined records include every metric
+		all := middleware.RequireShareGrant(
+			models.ShareMetricHeartRate, models.ShareMetricSteps, models.ShareMetricCalories, models.ShareMetricActivity,
+		)
+		health.GET("/current", all, controllers.GetCurrentHealthData)
+		health.GET("/history", all, controllers.GetHealthDataHistory)
+		health.GET("/summary", all, controllers.GetHealthDataSummary)
+
+		health.GET("/heart-rate/history", middleware.RequireShareGrant(models.ShareMetricHeartRate), controllers.GetHeartRateHistory)
+		health.GET("/steps/history", middleware.RequireShareGrant(models.ShareMetricSteps), controllers.GetStepsHistory)
+		health.GET("/calories/history", middleware.RequireShareGrant(models.ShareMetricCalories), controllers.GetCaloriesHistory)
+		health.GET("/activity/history", middleware.RequireShareGrant(models.ShareMetricActivity), controllers.GetActivityStatusHistory)
+	}
+}
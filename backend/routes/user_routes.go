@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+)
+
+// SetupUserRoutes configures routes for the authenticated user's own account
+func SetupUserRoutes(router *gin.Engine) {
+	me := router.Group("/api/me")
+	me.Use(middleware.AuthMiddleware(), middleware.RateLimit("read"))
+	{
+		me.GET("/profile", controllers.GetProfile)
+		me.PUT("/profile", controllers.UpdateProfile)
+		me.DELETE("", middleware.AuditLog("account"), controllers.DeleteAccount)
+
+		// Sharing changes are recorded in the audit log
+		sharing := middleware.AuditLog("sharing")
+
+		// Data shared with other accounts
+		me.GET("/shares", controllers.GetShareGrants)
+		me.POST("/shares", sharing, controllers.CreateShareGrant)
+		me.DELETE("/shares/:id", sharing, controllers.RevokeShareGrant)
+
+		// Data other accounts shared with this user
+		me.GET("/shared-with-me", controllers.GetSharedWithMe)
+		me.POST("/shared-with-me/:id/accept", sharing, controllers.AcceptShareGrant)
+		me.DELETE("/shared-with-me/:id", sharing, controllers.RevokeShareGrant)
+
+		// Organizations monitoring this user as a patient
+		me.GET("/enrollments", controllers.GetMyEnrollments)
+		me.POST("/enrollments/:id/consent", sharing, controllers.ConsentToEnrollment)
+		me.DELETE("/enrollments/:id", sharing, controllers.WithdrawEnrollment)
+
+		// Personal data export
+		me.POST("/export", middleware.AuditLog("export"), controllers.StartDataExport)
+		me.GET("/export/:id", controllers.GetDataExport)
+
+		// Imports of history from other platforms
+		importing := middleware.AuditLog("import")
+		uploads := middleware.RateLimit("ingestion")
+		verified := middleware.RequireVerifiedEmail()
+		me.GET("/imports", controllers.GetImportJobs)
+		me.GET("/imports/:id", controllers.GetImportJob)
+		me.POST("/imports/apple-health", importing, uploads, verified, controllers.StartAppleHealthImport)
+		me.POST("/imports/activity-file", importing, uploads, verified, controllers.StartActivityFileImport)
+		me.POST("/imports/google-fit", importing, uploads, verified, controllers.StartGoogleFitImport)
+	}
+
+	// Export downloads are authorized by the signed link rather than a session
+	exports := router.Group("/api/exports")
+	exports.Use(middleware.AuditLog("export"), middleware.RateLimit("read"))
+	{
+		exports.GET("/:id/download", controllers.DownloadDataExport)
+	}
+}
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/habdil/notify-vital/backend/controllers"
+	"github.com/habdil/notify-vital/backend/middleware"
+)
+
+// SetupWorkoutRoutes configures all workout session routes
+func SetupWorkoutRoutes(router *gin.Engine) {
+	// All workout routes require authentication
+	workouts := router.Group("/api/workouts")
+	workouts.Use(middleware.AuditLog("health"), middleware.AuthMiddleware())
+
+	// Reads and writes are rate limited separately, as for health data
+	read := middleware.RateLimit("read")
+	ingestion := middleware.RateLimit("ingestion")
+	{
+		workouts.GET("", read, controllers.GetWorkouts)
+		workouts.POST("/start", ingestion, controllers.StartWorkout)
+		workouts.GET("/:id", read, controllers.GetWorkout)
+		workouts.PATCH("/:id", ingestion, controllers.UpdateWorkout)
+		workouts.POST("/:id/stop", ingestion, controllers.StopWorkout)
+
+		// Automatically detected workouts
+		workouts.POST("/:id/confirm", ingestion, controllers.ConfirmWorkout)
+		workouts.POST("/:id/discard", ingestion, controllers.DiscardWorkout)
+	}
+}
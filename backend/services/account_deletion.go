@@ -0,0 +1,264 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/habdil/notify-vital/backend/config"
+)
+
+const cancelDeletionPurpose = "cancel_deletion"
+
+var (
+	ErrInvalidPassword          = errors.New("password is incorrect")
+	ErrInvalidCancellationToken = errors.New("invalid or expired cancellation link")
+	ErrAccountPendingDeletion   = errors.New("account is scheduled for deletion; use the link in the confirmation email to cancel")
+)
+
+// accountPurgeStatements delete everything stored about a user, children
+// before parents. The audit log is kept as the record of who accessed what.
+var accountPurgeStatements = []string{
+	"DELETE FROM heart_rate_data WHERE user_id = $1",
+	"DELETE FROM steps_data WHERE user_id = $1",
+	"DELETE FROM calories_data WHERE user_id = $1",
+	"DELETE FROM activity_status_updates WHERE user_id = $1",
+	"DELETE FROM health_data WHERE user_id = $1",
+	"DELETE FROM workouts WHERE user_id = $1",
+	"DELETE FROM sessions WHERE user_id = $1",
+	"DELETE FROM password_reset_tokens WHERE user_id = $1",
+	"DELETE FROM mfa_challenges WHERE user_id = $1",
+	"DELETE FROM mfa_recovery_codes WHERE user_id = $1",
+	"DELETE FROM user_mfa WHERE user_id = $1",
+	"DELETE FROM account_lockouts WHERE user_id = $1",
+	"DELETE FROM share_grants WHERE owner_id = $1 OR grantee_id = $1",
+	"DELETE FROM patient_alerts WHERE user_id = $1",
+	"UPDATE patient_alerts SET acknowledged_by = NULL WHERE acknowledged_by = $1",
+	"DELETE FROM patient_enrollments WHERE patient_id = $1",
+	"UPDATE patient_enrollments SET invited_by = NULL WHERE invited_by = $1",
+	"DELETE FROM organization_members WHERE user_id = $1",
+	"UPDATE organizations SET created_by = NULL WHERE created_by = $1",
+	"DELETE FROM data_exports WHERE user_id = $1",
+	"DELETE FROM import_jobs WHERE user_id = $1",
+	"DELETE FROM imported_devices WHERE user_id = $1",
+	"DELETE FROM users WHERE user_id = $1",
+}
+
+// accountDeletionGrace returns how long a deleted account can still be restored
+func accountDeletionGrace() time.Duration {
+	return config.GetEnvDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+}
+
+// RequestAccountDeletion deactivates the account and ends its sessions
+// immediately, and schedules its data to be purged after the grace period.
+// A link to cancel is emailed to the user.
+func RequestAccountDeletion(userID int, password string) (time.Time, error) {
+	var username, email, passwordHash string
+
+	err := config.DB.QueryRow(
+		"SELECT username, email, password_hash FROM users WHERE user_id = $1",
+		userID,
+	).Scan(&username, &email, &passwordHash)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !CheckPasswordHash(password, passwordHash) {
+		return time.Time{}, ErrInvalidPassword
+	}
+
+	now := time.Now()
+	scheduledFor := now.Add(accountDeletionGrace())
+
+	// The link carries a nonce stored with this request, so it only cancels it
+	nonce, err := randomToken(24)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = config.DB.Exec(
+		`UPDATE users SET is_active = false, deletion_requested_at = $1, deletion_scheduled_for = $2,
+		        deletion_cancel_hash = $3
+		 WHERE user_id = $4`,
+		now, scheduledFor, hashToken(nonce), userID,
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err := RevokeAllSessions(userID); err != nil {
+		return time.Time{}, err
+	}
+
+	token, err := signPurposeToken(cancelDeletionPurpose, jwt.MapClaims{
+		"user_id": userID,
+		"nonce":   nonce,
+	}, time.Until(scheduledFor))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = GetMailer().Send(EmailMessage{
+		To:      email,
+		Subject: "Your Notify Vital account will be deleted",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account has been deactivated and all of your data will be permanently deleted on %s.\n\n"+
+				"If you change your mind before then, open this link to keep your account:\n%s",
+			username, scheduledFor.Format("2 January 2006"), appURL("/cancel-deletion?token="+url.QueryEscape(token)),
+		),
+	})
+	if err != nil {
+		log.Printf("Failed to send deletion confirmation to user %d: %v", userID, err)
+	}
+
+	return scheduledFor, nil
+}
+
+// CancelAccountDeletion restores an account scheduled for deletion
+func CancelAccountDeletion(token string) error {
+	claims, err := parsePurposeToken(token, cancelDeletionPurpose)
+	if err != nil {
+		return ErrInvalidCancellationToken
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	nonce, nonceOK := claims["nonce"].(string)
+	if !ok || !nonceOK {
+		return ErrInvalidCancellationToken
+	}
+
+	result, err := config.DB.Exec(
+		`UPDATE users SET is_active = true, deletion_requested_at = NULL, deletion_scheduled_for = NULL,
+		        deletion_cancel_hash = NULL
+		 WHERE user_id = $1 AND deletion_cancel_hash = $2 AND deletion_scheduled_for > $3`,
+		int(userID), hashToken(nonce), time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrInvalidCancellationToken
+	}
+
+	return nil
+}
+
+// StartAccountPurger periodically purges accounts whose grace period has
+// passed, every ACCOUNT_PURGE_INTERVAL
+func StartAccountPurger() {
+	interval := config.GetEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour)
+
+	go func() {
+		for {
+			PurgeDueAccounts()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// PurgeDueAccounts permanently deletes accounts whose deletion is due
+func PurgeDueAccounts() {
+	rows, err := config.DB.Query(
+		"SELECT user_id FROM users WHERE deletion_scheduled_for <= $1 AND NOT is_active",
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("Failed to find accounts to purge: %v", err)
+		return
+	}
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			log.Printf("Failed to read account to purge: %v", err)
+			break
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to find accounts to purge: %v", err)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		if err := purgeAccount(userID); err != nil {
+			log.Printf("Failed to purge account %d: %v", userID, err)
+			continue
+		}
+		log.Printf("Purged account %d", userID)
+	}
+}
+
+// purgeAccount deletes a user and all of their data in one transaction
+func purgeAccount(userID int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Re-check under lock in case the deletion was cancelled meanwhile
+	var email string
+	err = tx.QueryRow(
+		"SELECT email FROM users WHERE user_id = $1 AND deletion_scheduled_for <= $2 AND NOT is_active FOR UPDATE",
+		userID, time.Now(),
+	).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	// Export archives live on disk; remove them once the rows are gone
+	var exportFiles []string
+	rows, err := tx.Query("SELECT file_path FROM data_exports WHERE user_id = $1 AND file_path IS NOT NULL", userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return err
+		}
+		exportFiles = append(exportFiles, path)
+	}
+	rows.Close()
+
+	for _, statement := range accountPurgeStatements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, path := range exportFiles {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete export file %s: %v", path, err)
+		}
+	}
+
+	if err := GetLoginAttemptStore().Reset(accountThrottleKey(email)); err != nil {
+		log.Printf("Failed to clear login attempts of purged account %d: %v", userID, err)
+	}
+
+	sessionCache.invalidateUser(userID)
+	return nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// tcxDatabase is the part of a Garmin Training Center (TCX) file read by the
+// importer
+type tcxDatabase struct {
+	Activities []struct {
+		Sport   string `xml:"Sport,attr"`
+		Creator string `xml:"Creator>Name"`
+		Laps    []struct {
+			Calories    float64 `xml:"Calories"`
+			Trackpoints []struct {
+				Time           string   `xml:"Time"`
+				DistanceMeters *float64 `xml:"DistanceMeters"`
+				HeartRate      *float64 `xml:"HeartRateBpm>Value"`
+				RunCadence     *float64 `xml:"Extensions>TPX>RunCadence"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// gpxFile is the part of a GPX file read by the importer. Heart rate and
+// cadence come from the Garmin TrackPointExtension.
+type gpxFile struct {
+	Creator string `xml:"creator,attr"`
+	Tracks  []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat       float64  `xml:"lat,attr"`
+				Lon       float64  `xml:"lon,attr"`
+				Time      string   `xml:"time"`
+				HeartRate *float64 `xml:"extensions>TrackPointExtension>hr"`
+				Cadence   *float64 `xml:"extensions>TrackPointExtension>cad"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// decodeTCX reads the activities of a TCX file
+func decodeTCX(r io.Reader) ([]parsedActivity, error) {
+	var database tcxDatabase
+	if err := xml.NewDecoder(r).Decode(&database); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+
+	var activities []parsedActivity
+	for _, tcxActivity := range database.Activities {
+		activity := parsedActivity{Sport: activitySport(tcxActivity.Sport), Device: tcxActivity.Creator}
+		var steps float64
+		var previous time.Time
+
+		for _, lap := range tcxActivity.Laps {
+			var lapEnd time.Time
+
+			for _, trackpoint := range lap.Trackpoints {
+				timestamp, err := time.Parse(time.RFC3339, trackpoint.Time)
+				if err != nil {
+					continue
+				}
+				point := activityPoint{Time: timestamp, HeartRate: trackpoint.HeartRate, Distance: trackpoint.DistanceMeters}
+
+				// Run cadence is strides per minute; steps are its integral
+				if trackpoint.RunCadence != nil {
+					if !previous.IsZero() && timestamp.After(previous) {
+						steps += 2 * *trackpoint.RunCadence * timestamp.Sub(previous).Minutes()
+					}
+					point.Steps = nullableFloat(math.Round(steps), true)
+				}
+
+				activity.Points = append(activity.Points, point)
+				previous, lapEnd = timestamp, timestamp
+			}
+
+			if !lapEnd.IsZero() {
+				activity.Laps = append(activity.Laps, activityLap{End: lapEnd, Calories: lap.Calories})
+			}
+		}
+
+		activities = append(activities, activity)
+	}
+
+	return activities, nil
+}
+
+// decodeGPX reads the tracks of a GPX file. Distance is computed from the
+// track's coordinates, since GPX does not record it.
+func decodeGPX(r io.Reader) ([]parsedActivity, error) {
+	var gpx gpxFile
+	if err := xml.NewDecoder(r).Decode(&gpx); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+
+	var activities []parsedActivity
+	for _, track := range gpx.Tracks {
+		activity := parsedActivity{Sport: activitySport(track.Type), Device: gpx.Creator}
+		var distance, steps float64
+		var previous time.Time
+
+		for _, segment := range track.Segments {
+			// Distance is not accumulated across the gap between segments
+			var lastLat, lastLon float64
+			first := true
+
+			for _, trackpoint := range segment.Points {
+				timestamp, err := time.Parse(time.RFC3339, trackpoint.Time)
+				if err != nil {
+					continue
+				}
+
+				if !first {
+					distance += haversineMeters(lastLat, lastLon, trackpoint.Lat, trackpoint.Lon)
+				}
+				lastLat, lastLon, first = trackpoint.Lat, trackpoint.Lon, false
+
+				point := activityPoint{Time: timestamp, HeartRate: trackpoint.HeartRate}
+				point.Distance = nullableFloat(distance, true)
+
+				if trackpoint.Cadence != nil && (activity.Sport == "running" || activity.Sport == "walking" || activity.Sport == "hiking") {
+					if !previous.IsZero() && timestamp.After(previous) {
+						steps += 2 * *trackpoint.Cadence * timestamp.Sub(previous).Minutes()
+					}
+					point.Steps = nullableFloat(math.Round(steps), true)
+				}
+
+				activity.Points = append(activity.Points, point)
+				previous = timestamp
+			}
+		}
+
+		activities = append(activities, activity)
+	}
+
+	return activities, nil
+}
+
+// haversineMeters returns the great-circle distance between two coordinates
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// activityPoint is one sample of a recorded activity. Distance and steps
+// are cumulative from the start of the activity.
+type activityPoint struct {
+	Time      time.Time
+	HeartRate *float64
+	Distance  *float64
+	Steps     *float64
+}
+
+// activityLap holds the calories burned during one lap
+type activityLap struct {
+	End      time.Time
+	Calories float64
+}
+
+// parsedActivity is a workout recorded by another device, decoded from a
+// FIT, TCX or GPX file. Device names the recording device when the file
+// does.
+type parsedActivity struct {
+	Sport  string
+	Device string
+	Points []activityPoint
+	Laps   []activityLap
+}
+
+// stepsBucket is the span steps and distance are aggregated over, as
+// per-second steps samples would swamp the steps history
+const stepsBucket = time.Minute
+
+// importActivityFile imports the activities of a FIT, TCX or GPX file. Each
+// activity's samples are stored and grouped into a completed workout.
+func importActivityFile(run *importRun, file *os.File) error {
+	header := make([]byte, 512)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	header = header[:n]
+
+	var activities []parsedActivity
+	var format string
+
+	switch {
+	case isFITHeader(header):
+		format = "FIT"
+		activity, err := decodeFIT(file)
+		if err != nil {
+			return err
+		}
+		activities = append(activities, *activity)
+	case bytes.Contains(header, []byte("<TrainingCenterDatabase")):
+		format = "TCX"
+		activities, err = decodeTCX(file)
+	case bytes.Contains(header, []byte("<gpx")):
+		format = "GPX"
+		activities, err = decodeGPX(file)
+	default:
+		return fmt.Errorf("%w: expected a FIT, TCX or GPX activity file", ErrInvalidImportFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, activity := range activities {
+		// Files that don't name their device are attributed to the format
+		if strings.TrimSpace(activity.Device) == "" {
+			activity.Device = "Unknown " + format + " device"
+		}
+		if err := importActivity(run, activity); err != nil {
+			return err
+		}
+	}
+
+	if len(activities) == 0 {
+		return fmt.Errorf("%w: the file contains no activities", ErrInvalidImportFile)
+	}
+
+	return nil
+}
+
+// importActivity stores the samples of one activity and creates a workout
+// for it. Heart rate is stored per sample, steps and distance per minute
+// and calories per lap.
+func importActivity(run *importRun, activity parsedActivity) error {
+	sort.Slice(activity.Points, func(i, j int) bool {
+		return activity.Points[i].Time.Before(activity.Points[j].Time)
+	})
+
+	if len(activity.Points) == 0 {
+		return nil
+	}
+
+	sport := activity.Sport
+
+	deviceID, err := importedDeviceID(run.job.UserID, activity.Device)
+	if err != nil {
+		return err
+	}
+
+	var lastSteps, lastDistance, emittedSteps, emittedDistance float64
+	hasDistance := false
+	var bucketEnd time.Time
+	var bucketHasData bool
+
+	emitSteps := func() error {
+		if !bucketHasData {
+			return nil
+		}
+		bucketHasData = false
+
+		steps := lastSteps - emittedSteps
+		distance := lastDistance - emittedDistance
+		emittedSteps, emittedDistance = lastSteps, lastDistance
+
+		if steps <= 0 && distance <= 0 {
+			return nil
+		}
+
+		sample := importedSample{Metric: models.ShareMetricSteps, Timestamp: bucketEnd, Value: steps, ImportedDeviceID: &deviceID}
+		if hasDistance {
+			sample.Distance = &distance
+		}
+		return run.add(sample)
+	}
+
+	for _, point := range activity.Points {
+		if point.HeartRate != nil && *point.HeartRate > 0 {
+			err := run.add(importedSample{
+				Metric: models.ShareMetricHeartRate, Timestamp: point.Time, Value: *point.HeartRate,
+				ActivityType: &sport, ImportedDeviceID: &deviceID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if point.Steps == nil && point.Distance == nil {
+			continue
+		}
+
+		// Close the previous minute once a point falls in a later one
+		if bucketHasData && point.Time.Truncate(stepsBucket).After(bucketEnd.Truncate(stepsBucket)) {
+			if err := emitSteps(); err != nil {
+				return err
+			}
+		}
+
+		if point.Steps != nil {
+			lastSteps = *point.Steps
+		}
+		if point.Distance != nil {
+			hasDistance = true
+			lastDistance = *point.Distance
+		}
+		bucketEnd = point.Time
+		bucketHasData = true
+	}
+
+	if err := emitSteps(); err != nil {
+		return err
+	}
+
+	for _, lap := range activity.Laps {
+		if lap.Calories <= 0 {
+			continue
+		}
+		err := run.add(importedSample{
+			Metric: models.ShareMetricCalories, Timestamp: lap.End, Value: lap.Calories,
+			ActivityType: &sport, ImportedDeviceID: &deviceID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The samples must be stored before the workout can be linked to them
+	if err := run.flush(); err != nil {
+		return err
+	}
+
+	start := activity.Points[0].Time
+	end := activity.Points[len(activity.Points)-1].Time
+	return createImportedWorkout(run.job.UserID, sport, start, end)
+}
+
+// importedDeviceID returns the ID of the user's imported device with the
+// given name, registering it the first time it is seen
+func importedDeviceID(userID int, name string) (int, error) {
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+
+	var id int
+	err := config.DB.QueryRow(
+		`INSERT INTO imported_devices (user_id, name) VALUES ($1, $2)
+		 ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name
+		 RETURNING id`,
+		userID, name,
+	).Scan(&id)
+	return id, err
+}
+
+// createImportedWorkout records an imported activity as a completed workout
+// linked to the samples in its time span. Importing the same activity again
+// does not create a second workout.
+func createImportedWorkout(userID int, sport string, start, end time.Time) error {
+	start, end = start.Local(), end.Local()
+
+	var exists bool
+	err := config.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM workouts WHERE user_id = $1 AND start_time = $2 AND status <> $3)",
+		userID, start, models.WorkoutStatusDiscarded,
+	).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	workout, err := scanWorkout(config.DB.QueryRow(
+		`INSERT INTO workouts (user_id, workout_type, status, start_time, end_time)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING `+workoutColumns,
+		userID, sport, models.WorkoutStatusCompleted, start, end,
+	))
+	if err != nil {
+		return err
+	}
+
+	_, err = finalizeWorkout(workout)
+	return err
+}
+
+// activitySport normalizes a sport name from an activity file to a workout type
+func activitySport(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	switch name {
+	case "", "other", "generic", "0":
+		return "workout"
+	case "biking", "cycling", "ride", "1":
+		return "cycling"
+	case "run", "running", "9":
+		return "running"
+	case "walk", "walking", "10":
+		return "walking"
+	case "hike", "hiking", "4":
+		return "hiking"
+	}
+
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	return name
+}
+
+// nullableFloat returns a pointer to v when valid is set
+func nullableFloat(v float64, valid bool) *float64 {
+	if !valid {
+		return nil
+	}
+	return &v
+}
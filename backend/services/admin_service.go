@@ -0,0 +1,206 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+var ErrCannotModifySelf = errors.New("administrators cannot deactivate or demote their own account")
+
+// ListUsers searches users by username or email, role and active state.
+// It also returns the total number of matches for pagination.
+func ListUsers(filters models.UserFilters) ([]models.User, int, error) {
+	where := " WHERE true"
+	var args []interface{}
+	argCount := 1
+
+	if filters.Query != "" {
+		where += fmt.Sprintf(" AND (username ILIKE $%d OR email ILIKE $%d)", argCount, argCount)
+		args = append(args, "%"+filters.Query+"%")
+		argCount++
+	}
+
+	if filters.Role != "" {
+		where += fmt.Sprintf(" AND role = $%d", argCount)
+		args = append(args, filters.Role)
+		argCount++
+	}
+
+	if filters.Active != nil {
+		where += fmt.Sprintf(" AND is_active = $%d", argCount)
+		args = append(args, *filters.Active)
+		argCount++
+	}
+
+	var total int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT user_id, username, email, created_at, last_login, is_active, role, email_verified_at IS NOT NULL,
+		       EXISTS (SELECT 1 FROM user_mfa WHERE user_mfa.user_id = users.user_id AND enabled)
+		FROM users` + where +
+		fmt.Sprintf(" ORDER BY user_id LIMIT $%d OFFSET $%d", argCount, argCount+1)
+	args = append(args, filters.Limit, filters.Offset)
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+
+	for rows.Next() {
+		var user models.User
+		var lastLogin sql.NullTime
+
+		err := rows.Scan(
+			&user.UserID, &user.Username, &user.Email, &user.CreatedAt, &lastLogin, &user.IsActive, &user.Role,
+			&user.EmailVerified, &user.MFAEnabled,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		user.LastLogin = lastLogin.Time
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// SetUserActive activates or deactivates an account. Deactivating also ends
+// all of the user's sessions.
+func SetUserActive(adminID, userID int, active bool) error {
+	if adminID == userID && !active {
+		return ErrCannotModifySelf
+	}
+
+	result, err := config.DB.Exec("UPDATE users SET is_active = $1 WHERE user_id = $2", active, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	if !active {
+		return RevokeAllSessions(userID)
+	}
+
+	sessionCache.invalidateUser(userID)
+	return nil
+}
+
+// SetUserRole changes a user's role
+func SetUserRole(adminID, userID int, role string) error {
+	if adminID == userID && role != models.RoleAdmin {
+		return ErrCannotModifySelf
+	}
+
+	result, err := config.DB.Exec("UPDATE users SET role = $1 WHERE user_id = $2", role, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	// Cached sessions carry the old role
+	sessionCache.invalidateUser(userID)
+	return nil
+}
+
+// GetSystemStats collects account, session and data volume counts
+func GetSystemStats() (*models.SystemStats, error) {
+	now := time.Now()
+	stats := models.SystemStats{
+		Samples:     make(map[string]int64),
+		GeneratedAt: now,
+	}
+	stats.Users.ByRole = make(map[string]int)
+
+	err := config.DB.QueryRow(
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE is_active),
+		        COUNT(*) FILTER (WHERE email_verified_at IS NOT NULL),
+		        (SELECT COUNT(*) FROM user_mfa WHERE enabled),
+		        COUNT(*) FILTER (WHERE created_at >= $1)
+		 FROM users`,
+		now.AddDate(0, 0, -7),
+	).Scan(&stats.Users.Total, &stats.Users.Active, &stats.Users.Verified, &stats.Users.MFAEnabled, &stats.Users.NewLast7Days)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := config.DB.Query("SELECT role, COUNT(*) FROM users GROUP BY role")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		var count int
+		if err := rows.Scan(&role, &count); err != nil {
+			return nil, err
+		}
+		stats.Users.ByRole[role] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = config.DB.QueryRow(
+		"SELECT COUNT(DISTINCT COALESCE(family_id, session_id::text)) FROM sessions WHERE is_valid AND COALESCE(refresh_expires_at, expires_at) > $1",
+		now,
+	).Scan(&stats.ActiveSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM workouts").Scan(&stats.Workouts); err != nil {
+		return nil, err
+	}
+
+	sampleTables := map[string]string{
+		"heart_rate":      "heart_rate_data",
+		"steps":           "steps_data",
+		"calories":        "calories_data",
+		"activity_status": "activity_status_updates",
+	}
+
+	for name, table := range sampleTables {
+		var count int64
+		if err := config.DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			return nil, err
+		}
+		stats.Samples[name] = count
+	}
+
+	return &stats, nil
+}
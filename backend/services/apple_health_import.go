@@ -0,0 +1,301 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// appleHealthDateLayout is the date format of Apple Health export records
+const appleHealthDateLayout = "2006-01-02 15:04:05 -0700"
+
+// appleHealthTypes maps the imported HealthKit quantity types to metrics
+var appleHealthTypes = map[string]string{
+	"HKQuantityTypeIdentifierHeartRate":          models.ShareMetricHeartRate,
+	"HKQuantityTypeIdentifierStepCount":          models.ShareMetricSteps,
+	"HKQuantityTypeIdentifierActiveEnergyBurned": models.ShareMetricCalories,
+}
+
+// appleHealthRecord is a parsed Record element with the app or device that
+// recorded it and that source's rank, lowest first
+type appleHealthRecord struct {
+	sample importedSample
+	source string
+	rank   int
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// importAppleHealth imports heart rate, steps and active energy records from
+// an Apple Health export.zip or its export.xml. The XML is parsed as a
+// stream, since exports often run to hundreds of megabytes. Steps and energy
+// are recorded by both the iPhone and the Watch, so those records are staged
+// in the database until the end and only one source's are imported for each
+// hour.
+func importAppleHealth(run *importRun, file *os.File) error {
+	defer func() {
+		_, err := config.DB.Exec("DELETE FROM import_staged_records WHERE import_job_id = $1", run.job.ID)
+		if err != nil {
+			log.Printf("Failed to delete staged records of import %d: %v", run.job.ID, err)
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var xmlReader io.Reader = file
+	xmlSize := info.Size()
+
+	if isZipFile(file) {
+		archive, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+		}
+
+		var entry *zip.File
+		for _, f := range archive.File {
+			if path.Base(f.Name) == "export.xml" {
+				entry = f
+				break
+			}
+		}
+		if entry == nil {
+			return fmt.Errorf("%w: export.xml not found in archive", ErrInvalidImportFile)
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+		}
+		defer rc.Close()
+
+		xmlReader = rc
+		xmlSize = int64(entry.UncompressedSize64)
+	}
+
+	counter := &countingReader{r: xmlReader}
+	decoder := xml.NewDecoder(counter)
+	sawRoot := false
+	var staged []appleHealthRecord
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if element.Name.Local == "HealthData" {
+			sawRoot = true
+			continue
+		}
+		if element.Name.Local != "Record" {
+			continue
+		}
+
+		record, ok := appleHealthSample(element.Attr)
+		if !ok {
+			continue
+		}
+
+		if record.sample.Metric == models.ShareMetricHeartRate {
+			if err := run.add(record.sample); err != nil {
+				return err
+			}
+		} else {
+			staged = append(staged, record)
+			if len(staged) >= importBatchSize {
+				if err := stageAppleHealthRecords(run.job.ID, staged); err != nil {
+					return err
+				}
+				staged = staged[:0]
+			}
+		}
+
+		// Progress is measured in the uploaded file's bytes
+		if xmlSize > 0 {
+			run.progress(int64(float64(counter.n) / float64(xmlSize) * float64(run.job.BytesTotal)))
+		}
+	}
+
+	if !sawRoot {
+		return fmt.Errorf("%w: not an Apple Health export", ErrInvalidImportFile)
+	}
+
+	if err := stageAppleHealthRecords(run.job.ID, staged); err != nil {
+		return err
+	}
+
+	return importPreferredAppleHealthRecords(run)
+}
+
+// stageAppleHealthRecords stores steps and energy records until a source can
+// be chosen for each hour
+func stageAppleHealthRecords(importJobID int, records []appleHealthRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	metrics := make([]string, len(records))
+	timestamps := make([]string, len(records))
+	values := make([]float64, len(records))
+	sources := make([]string, len(records))
+	ranks := make([]int64, len(records))
+
+	for i, record := range records {
+		metrics[i] = record.sample.Metric
+		timestamps[i] = importTimestamp(record.sample.Timestamp)
+		values[i] = record.sample.Value
+		sources[i] = record.source
+		ranks[i] = int64(record.rank)
+	}
+
+	_, err := config.DB.Exec(
+		`INSERT INTO import_staged_records (import_job_id, metric, timestamp, value, source, rank)
+		 SELECT $1, s.metric, s.ts, s.value, s.source, s.rank
+		 FROM unnest($2::text[], $3::timestamp[], $4::float8[], $5::text[], $6::integer[])
+			AS s(metric, ts, value, source, rank)`,
+		importJobID, pq.Array(metrics), pq.Array(timestamps), pq.Array(values), pq.Array(sources), pq.Array(ranks),
+	)
+	return err
+}
+
+// importPreferredAppleHealthRecords imports the staged records of one source
+// for each metric and hour: the Watch before the iPhone before other apps,
+// and among equals the one that recorded the most
+func importPreferredAppleHealthRecords(run *importRun) error {
+	rows, err := config.DB.Query(
+		`WITH sources AS (
+			SELECT metric, date_trunc('hour', timestamp) AS hour, source, MIN(rank) AS rank, SUM(value) AS total
+			FROM import_staged_records
+			WHERE import_job_id = $1
+			GROUP BY metric, date_trunc('hour', timestamp), source
+		), preferred AS (
+			SELECT DISTINCT ON (metric, hour) metric, hour, source
+			FROM sources
+			ORDER BY metric, hour, rank, total DESC, source
+		)
+		SELECT r.metric, r.timestamp, r.value
+		FROM import_staged_records r
+		JOIN preferred p ON p.metric = r.metric AND p.hour = date_trunc('hour', r.timestamp) AND p.source = r.source
+		WHERE r.import_job_id = $1
+		ORDER BY r.timestamp`,
+		run.job.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sample importedSample
+		if err := rows.Scan(&sample.Metric, &sample.Timestamp, &sample.Value); err != nil {
+			return err
+		}
+		sample.Timestamp = storedTime(sample.Timestamp)
+
+		if err := run.add(sample); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// appleHealthSourceRank orders record sources by their name and device,
+// lowest first
+func appleHealthSourceRank(source string) int {
+	source = strings.ToLower(source)
+
+	switch {
+	case strings.Contains(source, "watch"):
+		return 0
+	case strings.Contains(source, "iphone"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// appleHealthSample converts a Record element of an imported type. Records
+// of other types, or that cannot be read, are skipped.
+func appleHealthSample(attrs []xml.Attr) (appleHealthRecord, bool) {
+	values := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		values[attr.Name.Local] = attr.Value
+	}
+
+	metric, ok := appleHealthTypes[values["type"]]
+	if !ok {
+		return appleHealthRecord{}, false
+	}
+
+	value, err := strconv.ParseFloat(values["value"], 64)
+	if err != nil || value < 0 {
+		return appleHealthRecord{}, false
+	}
+
+	// Heart rate is an instant; steps and energy cover an interval and are
+	// attributed to its end, matching how devices report samples
+	date := values["endDate"]
+	if metric == models.ShareMetricHeartRate {
+		date = values["startDate"]
+	}
+
+	timestamp, err := time.Parse(appleHealthDateLayout, date)
+	if err != nil {
+		return appleHealthRecord{}, false
+	}
+
+	if metric == models.ShareMetricCalories && strings.EqualFold(values["unit"], "kJ") {
+		value /= 4.184
+	}
+
+	// The device attribute describes the hardware, e.g. "name:Apple Watch",
+	// where the source name may be the user's own name for it
+	return appleHealthRecord{
+		sample: importedSample{Metric: metric, Timestamp: timestamp, Value: value},
+		source: values["sourceName"],
+		rank:   appleHealthSourceRank(values["sourceName"] + " " + values["device"]),
+	}, true
+}
+
+// isZipFile reports whether a file starts with the ZIP signature
+func isZipFile(file *os.File) bool {
+	signature := make([]byte, 4)
+	if _, err := file.ReadAt(signature, 0); err != nil {
+		return false
+	}
+	return string(signature) == "PK\x03\x04"
+}
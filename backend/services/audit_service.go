@@ -0,0 +1,255 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// auditGenesisHash is the previous hash of the first entry in the chain
+const auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// auditChainLock is the advisory lock key that serializes appends to the
+// chain across instances
+const auditChainLock = 7710041
+
+// auditWriteAttempts is how many times an append is tried before the
+// failure is reported
+const auditWriteAttempts = 3
+
+// RecordAudit appends entries to the audit log, in order and under a single
+// lock. Failed appends are retried; entries that still can't be written are
+// logged in full so they can be restored, and the error returned.
+func RecordAudit(entries ...models.AuditEntry) error {
+	var err error
+
+	for attempt := 1; attempt <= auditWriteAttempts; attempt++ {
+		if err = appendAuditEntries(entries); err == nil {
+			return nil
+		}
+		if attempt < auditWriteAttempts {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+
+	for _, entry := range entries {
+		log.Printf("AUDIT WRITE FAILED after %d attempts: %v; entry: actor=%s subject=%s action=%s resource=%s method=%s status=%d ip=%s at=%s",
+			auditWriteAttempts, err, auditOptionalID(entry.ActorID), auditOptionalID(entry.SubjectUserID), entry.Action,
+			entry.Resource, entry.Method, entry.StatusCode, entry.IPAddress, entry.CreatedAt.Format(time.RFC3339Nano))
+	}
+
+	return err
+}
+
+// appendAuditEntries links entries to the last one in the chain and to each
+// other, and stores them in one statement
+func appendAuditEntries(entries []models.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", auditChainLock); err != nil {
+		return err
+	}
+
+	prevHash := auditGenesisHash
+	err = tx.QueryRow("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var values []string
+	var args []interface{}
+
+	for _, entry := range entries {
+		// Postgres keeps microseconds; hash exactly what is stored
+		entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+		entry.PrevHash = prevHash
+		entry.Hash = auditEntryHash(entry)
+		prevHash = entry.Hash
+
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10))
+		args = append(args,
+			entry.ActorID, entry.SubjectUserID, entry.Action, entry.Resource, entry.Method, entry.StatusCode,
+			entry.IPAddress, entry.CreatedAt, entry.PrevHash, entry.Hash,
+		)
+	}
+
+	// Rows are inserted in the order listed, so ids follow the chain
+	_, err = tx.Exec(
+		`INSERT INTO audit_log (
+			actor_id, subject_user_id, action, resource, method, status_code, ip_address, created_at, prev_hash, hash
+		) VALUES `+strings.Join(values, ", "),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// auditEntryHash hashes an entry's fields together with the previous hash,
+// so changing or removing any entry breaks every hash after it
+func auditEntryHash(entry models.AuditEntry) string {
+	content := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d|%s|%d",
+		entry.PrevHash, auditOptionalID(entry.ActorID), auditOptionalID(entry.SubjectUserID), entry.Action, entry.Resource,
+		entry.Method, entry.StatusCode, entry.IPAddress, entry.CreatedAt.UnixMicro(),
+	)
+
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditOptionalID formats an optional user ID, empty when unset
+func auditOptionalID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// auditColumns lists the columns read by scanAuditEntry
+const auditColumns = `id, actor_id, subject_user_id, action, resource, method, status_code,
+	COALESCE(ip_address, ''), created_at, prev_hash, hash`
+
+// scanAuditEntry scans a row selected with auditColumns
+func scanAuditEntry(row rowScanner) (*models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var actorID, subjectUserID sql.NullInt64
+
+	err := row.Scan(
+		&entry.ID, &actorID, &subjectUserID, &entry.Action, &entry.Resource, &entry.Method, &entry.StatusCode,
+		&entry.IPAddress, &entry.CreatedAt, &entry.PrevHash, &entry.Hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if actorID.Valid {
+		actorIDValue := int(actorID.Int64)
+		entry.ActorID = &actorIDValue
+	}
+
+	if subjectUserID.Valid {
+		subjectUserIDValue := int(subjectUserID.Int64)
+		entry.SubjectUserID = &subjectUserIDValue
+	}
+
+	return &entry, nil
+}
+
+// GetAuditLog lists audit entries, newest first. Unless allUsers is set, only
+// entries where userID is the actor or whose data was accessed are returned.
+func GetAuditLog(userID int, allUsers bool, filters models.AuditFilters) ([]models.AuditEntry, error) {
+	query := "SELECT " + auditColumns + " FROM audit_log WHERE true"
+	var args []interface{}
+	argCount := 1
+
+	scopeUserID := userID
+	if allUsers {
+		scopeUserID = filters.UserID
+	}
+
+	if scopeUserID != 0 {
+		query += fmt.Sprintf(" AND (subject_user_id = $%d OR actor_id = $%d)", argCount, argCount)
+		args = append(args, scopeUserID)
+		argCount++
+	}
+
+	if filters.Action != "" {
+		query += fmt.Sprintf(" AND action = $%d", argCount)
+		args = append(args, filters.Action)
+		argCount++
+	}
+
+	if filters.StartDate != "" {
+		query += fmt.Sprintf(" AND created_at >= $%d", argCount)
+		args = append(args, filters.StartDate)
+		argCount++
+	}
+
+	if filters.EndDate != "" {
+		query += fmt.Sprintf(" AND created_at <= $%d", argCount)
+		args = append(args, filters.EndDate)
+		argCount++
+	}
+
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", argCount, argCount+1)
+	args = append(args, filters.Limit, filters.Offset)
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// VerifyAuditChain recomputes every hash in the audit log and reports the
+// first entry that doesn't match its contents or its predecessor
+func VerifyAuditChain() (*models.AuditVerification, error) {
+	rows, err := config.DB.Query("SELECT " + auditColumns + " FROM audit_log ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := models.AuditVerification{Valid: true}
+	prevHash := auditGenesisHash
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.EntriesChecked++
+
+		entry.CreatedAt = entry.CreatedAt.UTC()
+		if entry.PrevHash != prevHash || auditEntryHash(*entry) != entry.Hash {
+			brokenAtID := entry.ID
+			result.Valid = false
+			result.BrokenAtID = &brokenAtID
+			break
+		}
+
+		prevHash = entry.Hash
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"database/sql"
 	"errors"
+	"log"
+	"net/http"
 	"os"
 	"time"
 
@@ -27,8 +30,9 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateJWT generates a JWT token for authentication
-func GenerateJWT(userID int) (string, time.Time, error) {
+// GenerateJWT generates a JWT token for authentication. The jti claim
+// identifies the session the token belongs to.
+func GenerateJWT(userID int, jti string) (string, time.Time, error) {
 	// Get JWT secret from environment
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -38,7 +42,7 @@ func GenerateJWT(userID int) (string, time.Time, error) {
 	// Get JWT expiry duration from environment
 	jwtExpiry := os.Getenv("JWT_EXPIRY")
 	if jwtExpiry == "" {
-		jwtExpiry = "24h" // Default to 24 hours if not set
+		jwtExpiry = "15m" // Short-lived; clients renew it with a refresh token
 	}
 
 	// Parse the expiry duration
@@ -52,6 +56,7 @@ func GenerateJWT(userID int) (string, time.Time, error) {
 	// Create the token with claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
+		"jti":     jti,
 		"exp":     expiryTime.Unix(),
 		"iat":     time.Now().Unix(),
 	})
@@ -65,12 +70,12 @@ func GenerateJWT(userID int) (string, time.Time, error) {
 	return tokenString, expiryTime, nil
 }
 
-// ValidateJWT validates the JWT token
-func ValidateJWT(tokenString string) (int, error) {
+// ValidateJWT validates the JWT token and returns its claims
+func ValidateJWT(tokenString string) (*models.TokenClaims, error) {
 	// Get JWT secret from environment
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
-		return 0, errors.New("JWT_SECRET not set in environment")
+		return nil, errors.New("JWT_SECRET not set in environment")
 	}
 
 	// Parse the token
@@ -83,7 +88,7 @@ func ValidateJWT(tokenString string) (int, error) {
 	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	// Validate the token and extract claims
@@ -91,13 +96,60 @@ func ValidateJWT(tokenString string) (int, error) {
 		// Extract user ID
 		userIDFloat, ok := claims["user_id"].(float64)
 		if !ok {
-			return 0, errors.New("invalid user_id in token")
+			return nil, errors.New("invalid user_id in token")
+		}
+
+		// Extract session ID; tokens without one cannot be revoked and are rejected
+		jti, ok := claims["jti"].(string)
+		if !ok || jti == "" {
+			return nil, errors.New("token has no session ID")
 		}
-		userID := int(userIDFloat)
-		return userID, nil
+
+		return &models.TokenClaims{UserID: int(userIDFloat), JTI: jti}, nil
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+// signPurposeToken signs a short-lived token that can only be used for the
+// given purpose, such as verifying an email. It is never accepted as an
+// access token because it carries no session ID.
+func signPurposeToken(purpose string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", errors.New("JWT_SECRET not set in environment")
 	}
 
-	return 0, errors.New("invalid token")
+	claims["purpose"] = purpose
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	claims["iat"] = time.Now().Unix()
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+}
+
+// parsePurposeToken validates a token created by signPurposeToken for the given purpose
+func parsePurposeToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET not set in environment")
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != purpose {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
 }
 
 // RegisterUser registers a new user
@@ -108,53 +160,116 @@ func RegisterUser(req models.RegisterRequest) (*models.User, error) {
 		return nil, err
 	}
 
-	// Create a new user in the database
+	// Create a new user in the database; the email starts unverified
 	var user models.User
 	err = config.DB.QueryRow(
-		"INSERT INTO users (username, email, password_hash, created_at, is_active) VALUES ($1, $2, $3, $4, $5) RETURNING user_id, username, email, created_at, is_active",
+		"INSERT INTO users (username, email, password_hash, created_at, is_active) VALUES ($1, $2, $3, $4, $5) RETURNING user_id, username, email, created_at, is_active, role",
 		req.Username,
 		req.Email,
 		hashedPassword,
 		time.Now(),
 		true,
-	).Scan(&user.UserID, &user.Username, &user.Email, &user.CreatedAt, &user.IsActive)
+	).Scan(&user.UserID, &user.Username, &user.Email, &user.CreatedAt, &user.IsActive, &user.Role)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// Delivery problems shouldn't fail registration; the link can be resent
+	if err := SendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.UserID, err)
+	}
+
 	return &user, nil
 }
 
-// LoginUser authenticates a user and returns user data and token
-func LoginUser(req models.LoginRequest) (*models.User, string, time.Time, error) {
+// LoginUser authenticates a user and returns user data and session tokens,
+// or an MFA challenge if the account has two-factor authentication enabled
+func LoginUser(req models.LoginRequest, meta models.SessionMetadata) (*models.LoginResult, error) {
+	// Refuse attempts while the account or IP address is backing off or locked
+	if err := checkLoginAllowed(req.Email, meta.IPAddress); err != nil {
+		return nil, err
+	}
+
 	// Find the user by email
 	var user models.User
 	var passwordHash string
+	var emailVerifiedAt sql.NullTime
+	var deletionScheduledFor sql.NullTime
 
 	err := config.DB.QueryRow(
-		"SELECT user_id, username, email, password_hash, created_at, is_active FROM users WHERE email = $1",
+		`SELECT user_id, username, email, password_hash, created_at, is_active, role, email_verified_at, deletion_scheduled_for
+		 FROM users WHERE email = $1`,
 		req.Email,
-	).Scan(&user.UserID, &user.Username, &user.Email, &passwordHash, &user.CreatedAt, &user.IsActive)
+	).Scan(
+		&user.UserID, &user.Username, &user.Email, &passwordHash, &user.CreatedAt, &user.IsActive, &user.Role,
+		&emailVerifiedAt, &deletionScheduledFor,
+	)
 
 	if err != nil {
-		return nil, "", time.Time{}, errors.New("invalid email or password")
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		recordLoginFailure(req.Email, meta.IPAddress, 0)
+		return nil, errors.New("invalid email or password")
 	}
 
 	// Check if the user is active
 	if !user.IsActive {
-		return nil, "", time.Time{}, errors.New("account is not active")
+		if deletionScheduledFor.Valid {
+			return nil, ErrAccountPendingDeletion
+		}
+		return nil, ErrAccountInactive
 	}
 
 	// Verify the password
 	if !CheckPasswordHash(req.Password, passwordHash) {
-		return nil, "", time.Time{}, errors.New("invalid email or password")
+		recordLoginFailure(req.Email, meta.IPAddress, user.UserID)
+		return nil, errors.New("invalid email or password")
+	}
+
+	// Depending on policy, unverified accounts may not log in yet
+	user.EmailVerified = emailVerifiedAt.Valid
+	if !user.EmailVerified && UnverifiedAccountPolicy() == PolicyBlockLogin {
+		return nil, ErrEmailNotVerified
+	}
+
+	// Accounts with two-factor authentication must complete a second step
+	var mfaEnabled bool
+	err = config.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled)",
+		user.UserID,
+	).Scan(&mfaEnabled)
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate JWT token
-	token, expiryTime, err := GenerateJWT(user.UserID)
+	// Failures are only cleared once every factor has been checked, so
+	// repeating the password step can't reset the count of wrong codes
+	if !mfaEnabled {
+		resetLoginFailures(req.Email)
+	}
+
+	if mfaEnabled {
+		token, expiresAt, err := createMFAChallenge(user.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &models.LoginResult{
+			User: &user,
+			MFAChallenge: &models.MFAChallengeResponse{
+				MFARequired: true,
+				MFAToken:    token,
+				ExpiresAt:   expiresAt.Format(http.TimeFormat),
+			},
+		}, nil
+	}
+
+	// Issue an access token and a refresh token for a new session
+	tokens, err := StartSession(user.UserID, meta)
 	if err != nil {
-		return nil, "", time.Time{}, err
+		return nil, err
 	}
 
 	// Update last login time
@@ -164,38 +279,83 @@ func LoginUser(req models.LoginRequest) (*models.User, string, time.Time, error)
 		// log.Printf("Failed to update last login: %v", err)
 	}
 
-	// Create a session record
-	_, err = config.DB.Exec(
-		"INSERT INTO sessions (user_id, token, ip_address, issued_at, expires_at, is_valid) VALUES ($1, $2, $3, $4, $5, $6)",
-		user.UserID, token, "", time.Now(), expiryTime, true,
+	return &models.LoginResult{User: &user, Tokens: tokens}, nil
+}
+
+// LogoutUser invalidates a user's session along with every refreshed
+// generation of it
+func LogoutUser(userID int, token string) error {
+	_, err := config.DB.Exec(
+		`UPDATE sessions SET is_valid = false
+		 WHERE token = $1
+		    OR family_id = (SELECT family_id FROM sessions WHERE token = $1)`,
+		token,
 	)
 	if err != nil {
-		// Non-critical error, just log it
-		// log.Printf("Failed to create session: %v", err)
+		return err
 	}
 
-	return &user, token, expiryTime, nil
-}
-
-// LogoutUser invalidates a user's session
-func LogoutUser(token string) error {
-	// Mark the session as invalid
-	_, err := config.DB.Exec("UPDATE sessions SET is_valid = false WHERE token = $1", token)
-	return err
+	sessionCache.invalidateUser(userID)
+	return nil
 }
 
 // GetUserByID retrieves a user by ID
 func GetUserByID(userID int) (*models.User, error) {
 	var user models.User
+	var dateOfBirth sql.NullTime
+	var weightKg sql.NullFloat64
+	var sex sql.NullString
+	var heightCm sql.NullFloat64
+	var walkingStrideCm sql.NullFloat64
+	var runningStrideCm sql.NullFloat64
+	var emailVerifiedAt sql.NullTime
 
 	err := config.DB.QueryRow(
-		"SELECT user_id, username, email, created_at, is_active FROM users WHERE user_id = $1",
+		`SELECT user_id, username, email, created_at, is_active, role, date_of_birth, weight_kg, sex,
+		        height_cm, walking_stride_cm, running_stride_cm, email_verified_at,
+		        EXISTS (SELECT 1 FROM user_mfa WHERE user_mfa.user_id = users.user_id AND enabled)
+		 FROM users WHERE user_id = $1`,
 		userID,
-	).Scan(&user.UserID, &user.Username, &user.Email, &user.CreatedAt, &user.IsActive)
+	).Scan(
+		&user.UserID, &user.Username, &user.Email, &user.CreatedAt, &user.IsActive, &user.Role, &dateOfBirth, &weightKg, &sex,
+		&heightCm, &walkingStrideCm, &runningStrideCm, &emailVerifiedAt, &user.MFAEnabled,
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	user.EmailVerified = emailVerifiedAt.Valid
+
+	if dateOfBirth.Valid {
+		dateOfBirthValue := dateOfBirth.Time
+		user.DateOfBirth = &dateOfBirthValue
+	}
+
+	if weightKg.Valid {
+		weightKgFloat := weightKg.Float64
+		user.WeightKg = &weightKgFloat
+	}
+
+	if sex.Valid {
+		sexStr := sex.String
+		user.Sex = &sexStr
+	}
+
+	if heightCm.Valid {
+		heightCmFloat := heightCm.Float64
+		user.HeightCm = &heightCmFloat
+	}
+
+	if walkingStrideCm.Valid {
+		walkingStrideCmFloat := walkingStrideCm.Float64
+		user.WalkingStrideCm = &walkingStrideCmFloat
+	}
+
+	if runningStrideCm.Valid {
+		runningStrideCmFloat := runningStrideCm.Float64
+		user.RunningStrideCm = &runningStrideCmFloat
+	}
+
 	return &user, nil
 }
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+var ErrProfileIncomplete = errors.New("weight is required in the user profile to estimate calories")
+
+const (
+	// estimationWindow is the granularity at which estimated calories are stored
+	estimationWindow = 15 * time.Minute
+
+	// maxEstimationRange limits how much history a single estimation request may cover
+	maxEstimationRange = 31 * 24 * time.Hour
+
+	// hrFormulaMinHeartRate is the lowest heart rate for which the HR-based
+	// formula is used; below it the formula underestimates badly
+	hrFormulaMinHeartRate = 90
+)
+
+// estimationLocks serializes background estimation per user, and
+// queuedEstimations holds the users with one waiting to start, so a burst of
+// uploads queues at most one run behind the one in progress
+var (
+	estimationLocks   sync.Map
+	queuedEstimations sync.Map
+)
+
+// ageAt returns the age in whole years at the given time
+func ageAt(dateOfBirth, at time.Time) int {
+	age := at.Year() - dateOfBirth.Year()
+	if at.Month() < dateOfBirth.Month() || (at.Month() == dateOfBirth.Month() && at.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return age
+}
+
+// maxHeartRateForUser estimates a user's max heart rate from their age,
+// falling back to defaultMaxHeartRate when the age is unknown
+func maxHeartRateForUser(user *models.User) int {
+	if user == nil || user.DateOfBirth == nil {
+		return defaultMaxHeartRate
+	}
+	return 220 - ageAt(*user.DateOfBirth, time.Now())
+}
+
+// heartRateCaloriesPerMinute estimates energy expenditure from heart rate
+// using the Keytel et al. (2005) equations. It reports false when the
+// profile lacks the age or sex the equations need.
+func heartRateCaloriesPerMinute(user *models.User, heartRate float64, at time.Time) (float64, bool) {
+	if user.DateOfBirth == nil || user.Sex == nil || user.WeightKg == nil {
+		return 0, false
+	}
+
+	age := float64(ageAt(*user.DateOfBirth, at))
+	weight := *user.WeightKg
+
+	var kilojoules float64
+	switch *user.Sex {
+	case "male":
+		kilojoules = -55.0969 + 0.6309*heartRate + 0.1988*weight + 0.2017*age
+	case "female":
+		kilojoules = -20.4022 + 0.4472*heartRate - 0.1263*weight + 0.074*age
+	default:
+		return 0, false
+	}
+
+	if kilojoules <= 0 {
+		return 0, false
+	}
+
+	return kilojoules / 4.184, true
+}
+
+// cadenceMET maps a step cadence (steps/min) to an approximate MET value
+func cadenceMET(cadence int) float64 {
+	switch {
+	case cadence == 0:
+		return 1.0
+	case cadence < 60:
+		return 2.0
+	case cadence < 100:
+		return 3.0
+	case cadence < 120:
+		return 4.0
+	case cadence < runningCadence:
+		return 5.0
+	default:
+		return 8.0
+	}
+}
+
+// estimateMinuteCalories estimates the calories burned during one minute of
+// activity, preferring heart rate and falling back to steps/MET
+func estimateMinuteCalories(user *models.User, minute activityMinute) float64 {
+	if minute.HasHR && minute.HeartRate >= hrFormulaMinHeartRate {
+		if calories, ok := heartRateCaloriesPerMinute(user, minute.HeartRate, minute.Start); ok {
+			return calories
+		}
+	}
+
+	return cadenceMET(minute.Steps) * 3.5 * *user.WeightKg / 200
+}
+
+// EstimateCalories fills calories for windows in the given range where the
+// device reported heart rate or steps but no calories. Estimated rows are
+// flagged with is_estimated and returned.
+func EstimateCalories(userID int, from, to time.Time) ([]models.CaloriesData, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.WeightKg == nil {
+		return nil, ErrProfileIncomplete
+	}
+
+	if to.Sub(from) > maxEstimationRange {
+		from = to.Add(-maxEstimationRange)
+	}
+	from = from.Truncate(estimationWindow)
+
+	// Find the windows that already have calories, reported or estimated
+	rows, err := config.DB.Query(
+		"SELECT timestamp FROM calories_data WHERE user_id = $1 AND timestamp >= $2 AND timestamp < $3",
+		userID, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covered := make(map[time.Time]bool)
+	for rows.Next() {
+		var timestamp time.Time
+		if err := rows.Scan(&timestamp); err != nil {
+			return nil, err
+		}
+		covered[timestamp.Truncate(estimationWindow)] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	minutes, err := loadActivityMinutes(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sum per-minute estimates into windows, keeping them in time order
+	var windows []time.Time
+	totals := make(map[time.Time]float64)
+	for _, minute := range minutes {
+		window := minute.Start.Truncate(estimationWindow)
+		if covered[window] {
+			continue
+		}
+		if _, seen := totals[window]; !seen {
+			windows = append(windows, window)
+		}
+		totals[window] += estimateMinuteCalories(user, minute)
+	}
+
+	var estimated []models.CaloriesData
+
+	for _, window := range windows {
+		// Leave the current, still-filling window for later
+		if window.Add(estimationWindow).After(to) {
+			continue
+		}
+
+		calories := int(math.Round(totals[window]))
+		if calories < 1 {
+			continue
+		}
+
+		caloriesData := models.CaloriesData{
+			UserID:         userID,
+			Timestamp:      window,
+			CaloriesBurned: calories,
+			IsEstimated:    true,
+			Source:         models.SourceEstimated,
+		}
+
+		// A concurrent estimation may have filled the window since it was checked
+		err := config.DB.QueryRow(
+			`INSERT INTO calories_data (user_id, timestamp, calories_burned, is_estimated, source)
+			 VALUES ($1, $2, $3, true, $4)
+			 ON CONFLICT (user_id, timestamp) WHERE is_estimated DO NOTHING
+			 RETURNING id, created_at`,
+			userID, window, calories, models.SourceEstimated,
+		).Scan(&caloriesData.ID, &caloriesData.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return estimated, err
+		}
+
+		estimated = append(estimated, caloriesData)
+	}
+
+	return estimated, nil
+}
+
+// EstimateCaloriesAsync fills calories for the recent windows without
+// reported calories in the background after new samples arrive. Users
+// without a weight in their profile are skipped.
+func EstimateCaloriesAsync(userID int) {
+	if _, queued := queuedEstimations.LoadOrStore(userID, struct{}{}); queued {
+		return
+	}
+
+	go func() {
+		lock, _ := estimationLocks.LoadOrStore(userID, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
+		// Samples arriving from here on need a new run to be seen
+		queuedEstimations.Delete(userID)
+
+		to := time.Now()
+		from := to.Add(-config.GetEnvDuration("CALORIE_ESTIMATION_LOOKBACK", 6*time.Hour))
+
+		_, err := EstimateCalories(userID, from, to)
+		if err != nil && !errors.Is(err, ErrProfileIncomplete) {
+			log.Printf("Calorie estimation failed for user %d: %v", userID, err)
+		}
+	}()
+}
+
+// clearEstimatedCalories removes estimates for the window containing a
+// device-reported calories sample, so the reported value takes precedence
+func clearEstimatedCalories(userID int, timestamp time.Time) error {
+	window := timestamp.Truncate(estimationWindow)
+
+	_, err := config.DB.Exec(
+		"DELETE FROM calories_data WHERE user_id = $1 AND is_estimated AND timestamp >= $2 AND timestamp < $3",
+		userID, window, window.Add(estimationWindow),
+	)
+	return err
+}
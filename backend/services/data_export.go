@@ -0,0 +1,503 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const dataExportPurpose = "data_export"
+
+var (
+	ErrExportNotFound     = errors.New("export not found")
+	ErrExportInProgress   = errors.New("an export is already in progress")
+	ErrExportNotReady     = errors.New("export is not ready for download")
+	ErrInvalidExportToken = errors.New("invalid or expired download link")
+)
+
+// exportTables are the health tables included in full in an export
+var exportTables = []string{
+	"health_data",
+	"heart_rate_data",
+	"steps_data",
+	"calories_data",
+	"activity_status_updates",
+	"workouts",
+	"imported_devices",
+	"patient_alerts",
+}
+
+// exportDir returns the directory export archives are written to
+func exportDir() string {
+	return config.GetEnv("EXPORT_DIR", "exports")
+}
+
+// exportExpiry returns how long a finished export can be downloaded
+func exportExpiry() time.Duration {
+	return config.GetEnvDuration("EXPORT_EXPIRY", 7*24*time.Hour)
+}
+
+// StartDataExport creates an export job for the user and runs it in the background
+func StartDataExport(userID int) (*models.DataExport, error) {
+	var inProgress bool
+	err := config.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM data_exports WHERE user_id = $1 AND status IN ($2, $3))",
+		userID, models.ExportStatusPending, models.ExportStatusRunning,
+	).Scan(&inProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	if inProgress {
+		return nil, ErrExportInProgress
+	}
+
+	export := models.DataExport{UserID: userID, Status: models.ExportStatusPending}
+	err = config.DB.QueryRow(
+		"INSERT INTO data_exports (user_id, status) VALUES ($1, $2) RETURNING id, created_at",
+		userID, export.Status,
+	).Scan(&export.ID, &export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	go runDataExport(export.ID, userID)
+
+	return &export, nil
+}
+
+// FailInterruptedExports marks exports left pending or running by a
+// previous server process as failed and deletes their partial archives, so
+// they don't block new exports. It is called once at startup.
+func FailInterruptedExports() {
+	rows, err := config.DB.Query(
+		"SELECT id, user_id FROM data_exports WHERE status IN ($1, $2)",
+		models.ExportStatusPending, models.ExportStatusRunning,
+	)
+	if err != nil {
+		log.Printf("Failed to find interrupted exports: %v", err)
+		return
+	}
+
+	var interrupted []int
+	for rows.Next() {
+		var id, userID int
+		if err := rows.Scan(&id, &userID); err != nil {
+			log.Printf("Failed to read interrupted export: %v", err)
+			break
+		}
+
+		path := filepath.Join(exportDir(), fmt.Sprintf("export-%d-%d.zip", userID, id))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete partial export %d: %v", id, err)
+		}
+		interrupted = append(interrupted, id)
+	}
+	rows.Close()
+
+	for _, id := range interrupted {
+		_, err := config.DB.Exec(
+			"UPDATE data_exports SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+			models.ExportStatusFailed, "interrupted by a server restart", time.Now(), id,
+		)
+		if err != nil {
+			log.Printf("Failed to fail interrupted export %d: %v", id, err)
+		}
+	}
+}
+
+// runDataExport builds the archive and records the outcome on the job
+func runDataExport(exportID, userID int) {
+	purgeExpiredExports()
+
+	_, err := config.DB.Exec("UPDATE data_exports SET status = $1 WHERE id = $2", models.ExportStatusRunning, exportID)
+	if err != nil {
+		log.Printf("Failed to start export %d: %v", exportID, err)
+		return
+	}
+
+	path := filepath.Join(exportDir(), fmt.Sprintf("export-%d-%d.zip", userID, exportID))
+
+	if err := writeExportArchive(path, userID); err != nil {
+		log.Printf("Export %d failed: %v", exportID, err)
+		os.Remove(path)
+
+		_, err = config.DB.Exec(
+			"UPDATE data_exports SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+			models.ExportStatusFailed, err.Error(), time.Now(), exportID,
+		)
+		if err != nil {
+			log.Printf("Failed to record export %d failure: %v", exportID, err)
+		}
+		return
+	}
+
+	now := time.Now()
+	_, err = config.DB.Exec(
+		"UPDATE data_exports SET status = $1, file_path = $2, completed_at = $3, expires_at = $4 WHERE id = $5",
+		models.ExportStatusCompleted, path, now, now.Add(exportExpiry()), exportID,
+	)
+	if err != nil {
+		log.Printf("Failed to record export %d completion: %v", exportID, err)
+	}
+}
+
+// writeExportArchive writes a ZIP with the user's profile, sessions,
+// devices and health records, each table as both JSON and CSV
+func writeExportArchive(path string, userID int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	// Each table is read twice, once per format, from the same snapshot
+	tx, err := config.DB.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := writeExportJSON(archive, "profile.json", user); err != nil {
+		return err
+	}
+
+	// Session tokens and refresh token hashes are never exported
+	sessionsQuery := `SELECT session_id, ip_address, user_agent, device_name, issued_at, expires_at, is_valid
+		FROM sessions WHERE user_id = $1 ORDER BY issued_at`
+	if err := writeExportTable(archive, tx, "sessions", sessionsQuery, userID); err != nil {
+		return err
+	}
+
+	devicesQuery := `SELECT device_id, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen, COUNT(*) AS records
+		FROM health_data WHERE user_id = $1 AND device_id IS NOT NULL GROUP BY device_id ORDER BY device_id`
+	if err := writeExportTable(archive, tx, "devices", devicesQuery, userID); err != nil {
+		return err
+	}
+
+	for _, table := range exportTables {
+		query := "SELECT * FROM " + table + " WHERE user_id = $1 ORDER BY 1"
+		if err := writeExportTable(archive, tx, table, query, userID); err != nil {
+			return fmt.Errorf("exporting %s: %w", table, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// writeExportJSON adds a value to the archive as an indented JSON file
+func writeExportJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// writeExportTable adds the rows of a query to the archive as <name>.json
+// and <name>.csv, keeping the query's column names. Rows are written as
+// they are read, so large tables are never held in memory.
+func writeExportTable(archive *zip.Writer, db queryer, name, query string, args ...interface{}) error {
+	w, err := archive.Create(name + ".json")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err = scanExportRows(db, query, args, nil, func(columns []string, values []interface{}) error {
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			record[column] = exportValue(values[i])
+		}
+
+		encoded, err := json.MarshalIndent(record, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		separator := ",\n  "
+		if first {
+			separator, first = "\n  ", false
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	closing := "\n]\n"
+	if first {
+		closing = "]\n"
+	}
+	if _, err := io.WriteString(w, closing); err != nil {
+		return err
+	}
+
+	w, err = archive.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(w)
+	err = scanExportRows(db, query, args, func(columns []string) error {
+		return csvWriter.Write(columns)
+	}, func(columns []string, values []interface{}) error {
+		row := make([]string, len(columns))
+		for i := range columns {
+			row[i] = exportCSVValue(exportValue(values[i]))
+		}
+		return csvWriter.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// scanExportRows runs a query and calls fn with each row's values. header,
+// if set, is called with the column names first.
+func scanExportRows(db queryer, query string, args []interface{}, header func([]string) error, fn func([]string, []interface{}) error) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if header != nil {
+		if err := header(columns); err != nil {
+			return err
+		}
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		if err := fn(columns, values); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// exportValue converts a scanned database value to a JSON-friendly one
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return v
+	}
+}
+
+// exportCSVValue formats a value for a CSV cell
+func exportCSVValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return SpreadsheetSafe(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// SpreadsheetSafe prefixes a CSV cell with a quote when a spreadsheet would
+// otherwise read it as a formula. Numbers such as "-5" are left as they are.
+func SpreadsheetSafe(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+	if _, err := strconv.ParseFloat(cell, 64); err == nil {
+		return cell
+	}
+	return "'" + cell
+}
+
+// GetDataExport retrieves an export job of the user. Completed exports
+// include a time-limited download link.
+func GetDataExport(userID, exportID int) (*models.DataExport, error) {
+	export, err := getDataExport(exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	if export.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+
+	if export.Status == models.ExportStatusCompleted && export.ExpiresAt != nil && time.Now().Before(*export.ExpiresAt) {
+		ttl := config.GetEnvDuration("EXPORT_LINK_EXPIRY", time.Hour)
+		if remaining := time.Until(*export.ExpiresAt); remaining < ttl {
+			ttl = remaining
+		}
+
+		token, err := signPurposeToken(dataExportPurpose, jwt.MapClaims{
+			"export_id": export.ID,
+			"user_id":   export.UserID,
+		}, ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		export.DownloadURL = fmt.Sprintf("/api/exports/%d/download?token=%s", export.ID, url.QueryEscape(token))
+	}
+
+	return export, nil
+}
+
+// getDataExport retrieves an export job by ID
+func getDataExport(exportID int) (*models.DataExport, error) {
+	var export models.DataExport
+	var filePath, exportError sql.NullString
+	var expiresAt, completedAt sql.NullTime
+
+	err := config.DB.QueryRow(
+		"SELECT id, user_id, status, file_path, error, expires_at, created_at, completed_at FROM data_exports WHERE id = $1",
+		exportID,
+	).Scan(&export.ID, &export.UserID, &export.Status, &filePath, &exportError, &expiresAt, &export.CreatedAt, &completedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	export.FilePath = filePath.String
+	export.Error = exportError.String
+
+	if expiresAt.Valid {
+		expiresAtValue := expiresAt.Time
+		export.ExpiresAt = &expiresAtValue
+	}
+
+	if completedAt.Valid {
+		completedAtValue := completedAt.Time
+		export.CompletedAt = &completedAtValue
+	}
+
+	return &export, nil
+}
+
+// OpenDataExport checks a download link and opens the export archive
+func OpenDataExport(exportID int, token string) (io.ReadSeekCloser, *models.DataExport, error) {
+	claims, err := parsePurposeToken(token, dataExportPurpose)
+	if err != nil {
+		return nil, nil, ErrInvalidExportToken
+	}
+
+	tokenExportID, ok := claims["export_id"].(float64)
+	if !ok || int(tokenExportID) != exportID {
+		return nil, nil, ErrInvalidExportToken
+	}
+
+	export, err := getDataExport(exportID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if export.Status != models.ExportStatusCompleted || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return nil, nil, ErrExportNotReady
+	}
+
+	file, err := os.Open(export.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, export, nil
+}
+
+// purgeExpiredExports deletes archives whose download window has passed
+func purgeExpiredExports() {
+	rows, err := config.DB.Query(
+		"SELECT id, file_path FROM data_exports WHERE expires_at < $1 AND file_path IS NOT NULL",
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("Failed to find expired exports: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var expired []int
+
+	for rows.Next() {
+		var id int
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			log.Printf("Failed to read expired export: %v", err)
+			return
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete export %d: %v", id, err)
+			continue
+		}
+		expired = append(expired, id)
+	}
+
+	for _, id := range expired {
+		if _, err := config.DB.Exec("UPDATE data_exports SET file_path = NULL WHERE id = $1", id); err != nil {
+			log.Printf("Failed to update expired export %d: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// sourcePeriod is the span within which samples from different sources are
+// treated as overlapping
+const sourcePeriod = "hour"
+
+// preferredSourceQuery builds a query selecting columns from one user's rows
+// of a metric table, keeping in each hour only the rows of the highest
+// ranked source in models.SourcePriority. Unranked sources come last.
+// extra adds columns computed before the date range is applied, over the
+// range and the row preceding it, so a window function such as LAG still
+// sees the sample before the first one in range.
+func preferredSourceQuery(table, columns, extra string, userID int, startDate, endDate string) (string, []interface{}) {
+	if extra != "" {
+		extra = ", " + extra
+	}
+
+	args := []interface{}{userID, pq.Array(models.SourcePriority), len(models.SourcePriority) + 1}
+	var window, conditions []string
+
+	if startDate != "" {
+		args = append(args, startDate)
+		window = append(window, fmt.Sprintf(
+			" AND timestamp >= COALESCE((SELECT MAX(timestamp) FROM %s WHERE user_id = $1 AND timestamp < $%d), $%d)",
+			table, len(args), len(args),
+		))
+		conditions = append(conditions, fmt.Sprintf(" AND timestamp >= $%d", len(args)))
+	}
+
+	if endDate != "" {
+		args = append(args, endDate)
+		window = append(window, fmt.Sprintf(" AND timestamp <= $%d", len(args)))
+		conditions = append(conditions, fmt.Sprintf(" AND timestamp <= $%d", len(args)))
+	}
+
+	query := `
+		SELECT ` + columns + `
+		FROM (
+			SELECT *, MIN(source_rank) OVER (PARTITION BY date_trunc('` + sourcePeriod + `', timestamp)) AS best_rank
+			FROM (
+				SELECT *` + extra + `, COALESCE(array_position($2::text[], source::text), $3) AS source_rank
+				FROM ` + table + `
+				WHERE user_id = $1` + strings.Join(window, "") + `
+			) ranked
+			WHERE user_id = $1` + strings.Join(conditions, "") + `
+		) preferred
+		WHERE source_rank = best_rank
+	`
+
+	return query, args
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"database/sql"
+
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const (
+	// Step length as a fraction of height when no stride is configured
+	walkingStrideRatio = 0.414
+	runningStrideRatio = 0.65
+
+	// maxCadenceInterval is the longest gap between steps samples over which
+	// a cadence is computed; longer gaps are assumed to be walking
+	maxCadenceInterval = 10 * 60
+)
+
+// stepsIntervalColumn computes the seconds since the user's previous steps sample
+const stepsIntervalColumn = `EXTRACT(EPOCH FROM timestamp - LAG(timestamp) OVER (PARTITION BY user_id ORDER BY timestamp))`
+
+// stepsIntervalFrom limits the rows stepsIntervalColumn is computed over to
+// those from the start placeholder on, plus the sample before it so the first
+// interval in range is still known. $1 must be the user ID.
+func stepsIntervalFrom(start string) string {
+	return `timestamp >= COALESCE((SELECT MAX(timestamp) FROM steps_data WHERE user_id = $1 AND timestamp < ` + start + `), ` + start + `)`
+}
+
+// strideProfile holds the walking and running step lengths in meters
+type strideProfile struct {
+	Walking float64
+	Running float64
+}
+
+// strideProfileForUser derives step lengths from the user's profile. It
+// returns nil when neither a stride nor a height is configured.
+func strideProfileForUser(user *models.User) *strideProfile {
+	var profile strideProfile
+
+	if user.HeightCm != nil {
+		profile.Walking = *user.HeightCm * walkingStrideRatio / 100
+		profile.Running = *user.HeightCm * runningStrideRatio / 100
+	}
+
+	if user.WalkingStrideCm != nil {
+		profile.Walking = *user.WalkingStrideCm / 100
+	}
+
+	if user.RunningStrideCm != nil {
+		profile.Running = *user.RunningStrideCm / 100
+	}
+
+	// Fall back to the one stride that is known
+	if profile.Walking == 0 {
+		profile.Walking = profile.Running
+	}
+	if profile.Running == 0 {
+		profile.Running = profile.Walking
+	}
+
+	if profile.Walking == 0 {
+		return nil
+	}
+
+	return &profile
+}
+
+// distance derives the distance in meters covered by a steps sample, using
+// the running stride when the cadence since the previous sample is high enough
+func (p *strideProfile) distance(steps int, intervalSeconds *float64) float64 {
+	stride := p.Walking
+
+	if intervalSeconds != nil && *intervalSeconds > 0 && *intervalSeconds <= maxCadenceInterval {
+		cadence := float64(steps) / (*intervalSeconds / 60)
+		if cadence >= runningCadence {
+			stride = p.Running
+		}
+	}
+
+	return float64(steps) * stride
+}
+
+// loadStrideProfile loads the stride profile for a user
+func loadStrideProfile(userID int) (*strideProfile, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return strideProfileForUser(user), nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// sumStepsDistance totals the distance of steps samples selected by a query
+// returning steps_count, distance and the interval since the previous sample.
+// Samples without a reported distance use the derived one.
+func sumStepsDistance(db queryer, profile *strideProfile, query string, args ...interface{}) (float64, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	total := 0.0
+
+	for rows.Next() {
+		var steps int
+		var distance *float64
+		var intervalSeconds *float64
+
+		if err := rows.Scan(&steps, &distance, &intervalSeconds); err != nil {
+			return 0, err
+		}
+
+		if distance != nil {
+			total += *distance
+		} else if profile != nil {
+			total += profile.distance(steps, intervalSeconds)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
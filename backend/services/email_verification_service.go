@@ -0,0 +1,133 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// Policies for accounts whose email has not been verified yet
+const (
+	PolicyAllow             = "allow"              // no restrictions
+	PolicyRestrictIngestion = "restrict_ingestion" // may log in and read, but not record health data
+	PolicyBlockLogin        = "block_login"        // may not log in at all
+)
+
+const emailVerificationPurpose = "email_verification"
+
+var (
+	ErrEmailNotVerified         = errors.New("email address has not been verified")
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+)
+
+// UnverifiedAccountPolicy returns the policy configured in UNVERIFIED_ACCOUNT_POLICY
+func UnverifiedAccountPolicy() string {
+	switch policy := config.GetEnv("UNVERIFIED_ACCOUNT_POLICY", PolicyRestrictIngestion); policy {
+	case PolicyAllow, PolicyBlockLogin:
+		return policy
+	default:
+		return PolicyRestrictIngestion
+	}
+}
+
+// SendVerificationEmail emails a signed verification link to the user
+func SendVerificationEmail(user *models.User) error {
+	expiry := config.GetEnvDuration("EMAIL_VERIFICATION_EXPIRY", 48*time.Hour)
+
+	// Binding the email means the link stops working if the address changes
+	token, err := signPurposeToken(emailVerificationPurpose, jwt.MapClaims{
+		"user_id": user.UserID,
+		"email":   user.Email,
+	}, expiry)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/verify-email?token=" + url.QueryEscape(token))
+
+	return GetMailer().Send(EmailMessage{
+		To:      user.Email,
+		Subject: "Verify your Notify Vital email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening this link:\n%s\n\nThe link expires in %s.",
+			user.Username, link, expiry,
+		),
+	})
+}
+
+// ResendVerificationEmail sends a new verification link to an unverified
+// account. Unknown or already verified emails are ignored.
+func ResendVerificationEmail(email string) error {
+	var user models.User
+	var emailVerifiedAt sql.NullTime
+
+	err := config.DB.QueryRow(
+		"SELECT user_id, username, email, email_verified_at FROM users WHERE email = $1 AND is_active",
+		email,
+	).Scan(&user.UserID, &user.Username, &user.Email, &emailVerifiedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if emailVerifiedAt.Valid {
+		return nil
+	}
+
+	return SendVerificationEmail(&user)
+}
+
+// VerifyEmail marks the email in a verification token as verified
+func VerifyEmail(token string) error {
+	claims, err := parsePurposeToken(token, emailVerificationPurpose)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	email, emailOK := claims["email"].(string)
+	if !ok || !emailOK {
+		return ErrInvalidVerificationToken
+	}
+
+	result, err := config.DB.Exec(
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, $1)
+		 WHERE user_id = $2 AND email = $3`,
+		time.Now(), int(userID), email,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrInvalidVerificationToken
+	}
+
+	return nil
+}
+
+// IsEmailVerified reports whether a user's email has been verified
+func IsEmailVerified(userID int) (bool, error) {
+	var verified bool
+
+	err := config.DB.QueryRow(
+		"SELECT email_verified_at IS NOT NULL FROM users WHERE user_id = $1",
+		userID,
+	).Scan(&verified)
+
+	return verified, err
+}
@@ -0,0 +1,485 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const (
+	loincSystem        = "http://loinc.org"
+	ucumSystem         = "http://unitsofmeasure.org"
+	fhirCategorySystem = "http://terminology.hl7.org/CodeSystem/observation-category"
+
+	defaultFHIRPageSize = 100
+	maxFHIRPageSize     = 1000
+)
+
+var (
+	ErrInvalidFHIRPatient  = errors.New("invalid patient reference")
+	ErrInvalidFHIRSearch   = errors.New("invalid search parameter")
+	ErrInvalidFHIRResource = errors.New("invalid FHIR resource")
+)
+
+// fhirMetric describes how a metric maps to an Observation
+type fhirMetric struct {
+	Metric   string
+	Code     string
+	Display  string
+	Unit     string
+	UnitCode string
+	Category string
+	IDPrefix string
+}
+
+// fhirMetrics lists the metrics exchanged as Observations, keyed by LOINC code
+var fhirMetrics = map[string]fhirMetric{
+	"8867-4": {
+		Metric: models.ShareMetricHeartRate, Code: "8867-4", Display: "Heart rate",
+		Unit: "beats/minute", UnitCode: "/min", Category: "vital-signs", IDPrefix: "hr",
+	},
+	"55423-8": {
+		Metric: models.ShareMetricSteps, Code: "55423-8", Display: "Number of steps in unspecified time Pedometer",
+		Unit: "steps", UnitCode: "{steps}", Category: "activity", IDPrefix: "steps",
+	},
+	"41981-2": {
+		Metric: models.ShareMetricCalories, Code: "41981-2", Display: "Calories burned",
+		Unit: "kcal", UnitCode: "kcal", Category: "activity", IDPrefix: "cal",
+	},
+}
+
+// fhirMetricOrder keeps the metric selection stable when no code is given
+var fhirMetricOrder = []string{"8867-4", "55423-8", "41981-2"}
+
+// fhirBaseURL returns the base of the FHIR endpoint, used in resource URLs
+func fhirBaseURL() string {
+	return strings.TrimRight(config.GetEnv("FHIR_BASE_URL", "http://localhost:3000/fhir"), "/")
+}
+
+// ParseFHIRPatient resolves a patient search parameter, "Patient/<id>" or a
+// bare ID, to a user ID
+func ParseFHIRPatient(reference string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(reference, "Patient/"))
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidFHIRPatient
+	}
+	return id, nil
+}
+
+// FHIRSearchMetrics returns the share metrics selected by a code search
+// parameter, a comma-separated list of LOINC codes optionally prefixed with
+// the system. An empty parameter selects every supported metric.
+func FHIRSearchMetrics(code string) ([]string, error) {
+	codes, err := parseFHIRCodes(code)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]string, 0, len(codes))
+	for _, code := range codes {
+		metrics = append(metrics, fhirMetrics[code].Metric)
+	}
+	return metrics, nil
+}
+
+// parseFHIRCodes returns the LOINC codes selected by a code search parameter
+func parseFHIRCodes(code string) ([]string, error) {
+	if code == "" {
+		return fhirMetricOrder, nil
+	}
+
+	var codes []string
+	for _, token := range strings.Split(code, ",") {
+		if system, value, found := strings.Cut(token, "|"); found {
+			if system != "" && system != loincSystem {
+				return nil, fmt.Errorf("%w: unsupported code system %q", ErrInvalidFHIRSearch, system)
+			}
+			token = value
+		}
+
+		if _, ok := fhirMetrics[token]; !ok {
+			return nil, fmt.Errorf("%w: unsupported code %q", ErrInvalidFHIRSearch, token)
+		}
+		codes = append(codes, token)
+	}
+
+	return dedupeStrings(codes), nil
+}
+
+// fhirDateCondition is a timestamp comparison from a date search parameter
+type fhirDateCondition struct {
+	Operator string
+	Value    time.Time
+}
+
+// parseFHIRDate turns a date search parameter such as "ge2024-01-01" or
+// "lt2024-01-02T08:00:00Z" into timestamp conditions. A plain date covers
+// the whole day. Times are in server local time, as samples are stored.
+func parseFHIRDate(param string) ([]fhirDateCondition, error) {
+	prefix := "eq"
+	if len(param) > 2 && param[0] >= 'a' && param[0] <= 'z' {
+		prefix, param = param[:2], param[2:]
+	}
+
+	start, err := time.Parse(time.RFC3339, param)
+	start = start.Local()
+	end := start
+	if err != nil {
+		start, err = time.ParseInLocation("2006-01-02", param, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid date %q", ErrInvalidFHIRSearch, param)
+		}
+		end = start.AddDate(0, 0, 1)
+	}
+
+	// A dateTime is an instant; a date is the half-open range [start, end)
+	exact := start.Equal(end)
+
+	switch prefix {
+	case "eq":
+		if exact {
+			return []fhirDateCondition{{"=", start}}, nil
+		}
+		return []fhirDateCondition{{">=", start}, {"<", end}}, nil
+	case "ge":
+		return []fhirDateCondition{{">=", start}}, nil
+	case "gt":
+		if exact {
+			return []fhirDateCondition{{">", start}}, nil
+		}
+		return []fhirDateCondition{{">=", end}}, nil
+	case "le":
+		if exact {
+			return []fhirDateCondition{{"<=", start}}, nil
+		}
+		return []fhirDateCondition{{"<", end}}, nil
+	case "lt":
+		return []fhirDateCondition{{"<", start}}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported date prefix %q", ErrInvalidFHIRSearch, prefix)
+	}
+}
+
+// SearchFHIRObservations returns a patient's heart rate, steps and calories
+// samples as a searchset Bundle of Observations, newest first. Access to the
+// patient must be checked by the caller.
+func SearchFHIRObservations(patientID int, search models.FHIRObservationSearch) (*models.FHIRBundle, error) {
+	codes, err := parseFHIRCodes(search.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []fhirDateCondition
+	for _, param := range search.Date {
+		parsed, err := parseFHIRDate(param)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, parsed...)
+	}
+
+	if search.Count <= 0 {
+		search.Count = defaultFHIRPageSize
+	}
+	if search.Count > maxFHIRPageSize {
+		search.Count = maxFHIRPageSize
+	}
+	if search.Offset < 0 {
+		search.Offset = 0
+	}
+
+	args := []interface{}{patientID}
+	dateClause := ""
+	for _, condition := range conditions {
+		args = append(args, condition.Value)
+		dateClause += fmt.Sprintf(" AND timestamp %s $%d", condition.Operator, len(args))
+	}
+
+	// One branch per selected metric, merged in time order
+	var branches []string
+	for _, code := range codes {
+		metric := fhirMetrics[code]
+		target := importedSampleTables[metric.Metric]
+
+		branch := fmt.Sprintf(
+			"SELECT '%s' AS code, id, device_id, timestamp, %s::float8 AS value, created_at FROM %s WHERE user_id = $1%s",
+			code, target.column, target.table, dateClause,
+		)
+		if metric.Metric == models.ShareMetricCalories {
+			// Estimates are not measurements, so they are not exchanged
+			branch += " AND NOT is_estimated"
+		}
+		branches = append(branches, branch)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	args = append(args, search.Count+1, search.Offset)
+	query := strings.Join(branches, " UNION ALL ") +
+		fmt.Sprintf(" ORDER BY timestamp DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bundle := &models.FHIRBundle{ResourceType: "Bundle", Type: "searchset"}
+
+	for rows.Next() {
+		var code string
+		var id int
+		var deviceID *int
+		var timestamp, createdAt time.Time
+		var value float64
+
+		if err := rows.Scan(&code, &id, &deviceID, &timestamp, &value, &createdAt); err != nil {
+			return nil, err
+		}
+
+		observation := fhirObservation(fhirMetrics[code], patientID, id, deviceID, timestamp, value, createdAt)
+		bundle.Entry = append(bundle.Entry, models.FHIRBundleEntry{
+			FullURL:  fhirBaseURL() + "/Observation/" + observation.ID,
+			Resource: observation,
+			Search:   &models.FHIRBundleSearch{Mode: "match"},
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hasNext := len(bundle.Entry) > search.Count
+	if hasNext {
+		bundle.Entry = bundle.Entry[:search.Count]
+	}
+
+	bundle.Link = append(bundle.Link, models.FHIRBundleLink{
+		Relation: "self", URL: fhirSearchURL(patientID, search, search.Offset),
+	})
+	if hasNext {
+		bundle.Link = append(bundle.Link, models.FHIRBundleLink{
+			Relation: "next", URL: fhirSearchURL(patientID, search, search.Offset+search.Count),
+		})
+	}
+
+	return bundle, nil
+}
+
+// fhirSearchURL builds the URL of a page of Observation search results
+func fhirSearchURL(patientID int, search models.FHIRObservationSearch, offset int) string {
+	query := url.Values{}
+	query.Set("patient", fmt.Sprintf("Patient/%d", patientID))
+	if search.Code != "" {
+		query.Set("code", search.Code)
+	}
+	for _, date := range search.Date {
+		query.Add("date", date)
+	}
+	query.Set("_count", strconv.Itoa(search.Count))
+	query.Set("_offset", strconv.Itoa(offset))
+
+	return fhirBaseURL() + "/Observation?" + query.Encode()
+}
+
+// fhirObservation maps a stored sample to an Observation
+func fhirObservation(metric fhirMetric, patientID, id int, deviceID *int, timestamp time.Time, value float64, createdAt time.Time) *models.FHIRObservation {
+	observation := &models.FHIRObservation{
+		ResourceType: "Observation",
+		ID:           fmt.Sprintf("%s-%d", metric.IDPrefix, id),
+		Status:       "final",
+		Category: []models.FHIRCodeableConcept{{
+			Coding: []models.FHIRCoding{{System: fhirCategorySystem, Code: metric.Category}},
+		}},
+		Code: models.FHIRCodeableConcept{
+			Coding: []models.FHIRCoding{{System: loincSystem, Code: metric.Code, Display: metric.Display}},
+			Text:   metric.Display,
+		},
+		Subject:           &models.FHIRReference{Reference: fmt.Sprintf("Patient/%d", patientID)},
+		EffectiveDateTime: storedTime(timestamp).Format(time.RFC3339),
+		Issued:            storedTime(createdAt).Format(time.RFC3339),
+		ValueQuantity: &models.FHIRQuantity{
+			Value: &value, Unit: metric.Unit, System: ucumSystem, Code: metric.UnitCode,
+		},
+	}
+
+	if deviceID != nil {
+		observation.Device = &models.FHIRReference{Display: fmt.Sprintf("Device %d", *deviceID)}
+	}
+
+	return observation
+}
+
+// ImportFHIRObservations stores the Observations of a Bundle, or a single
+// Observation, as the user's samples and returns a response Bundle with the
+// outcome of each entry. A transaction Bundle is all-or-nothing; other
+// bundles import their valid entries and report the rest.
+func ImportFHIRObservations(userID int, body []byte) (*models.FHIRBundle, error) {
+	var envelope struct {
+		ResourceType string `json:"resourceType"`
+		Type         string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFHIRResource, err)
+	}
+
+	var observations []*models.FHIRObservation
+	transaction := false
+
+	switch envelope.ResourceType {
+	case "Observation":
+		var observation models.FHIRObservation
+		if err := json.Unmarshal(body, &observation); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFHIRResource, err)
+		}
+		observations = append(observations, &observation)
+	case "Bundle":
+		var bundle models.FHIRBundle
+		if err := json.Unmarshal(body, &bundle); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFHIRResource, err)
+		}
+		for _, entry := range bundle.Entry {
+			observations = append(observations, entry.Resource)
+		}
+		transaction = bundle.Type == "transaction"
+	default:
+		return nil, fmt.Errorf("%w: expected an Observation or a Bundle", ErrInvalidFHIRResource)
+	}
+
+	responseType := "batch-response"
+	if transaction {
+		responseType = "transaction-response"
+	}
+	response := &models.FHIRBundle{ResourceType: "Bundle", Type: responseType}
+
+	// Validate everything before writing, so a transaction fails as a whole
+	samples := make([]*importedSample, len(observations))
+	for i, observation := range observations {
+		sample, err := fhirObservationSample(userID, observation)
+		if err != nil {
+			if transaction {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			response.Entry = append(response.Entry, models.FHIRBundleEntry{
+				Response: &models.FHIRBundleResponse{Status: "400 Bad Request", Outcome: FHIROutcome("invalid", err.Error())},
+			})
+			continue
+		}
+		samples[i] = sample
+		response.Entry = append(response.Entry, models.FHIRBundleEntry{})
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, sample := range samples {
+		if sample == nil {
+			continue
+		}
+
+		id, created, err := storeImportedSample(tx, userID, *sample)
+		if err != nil {
+			return nil, err
+		}
+
+		status := "201 Created"
+		if !created {
+			status = "200 OK"
+		}
+		response.Entry[i].Response = &models.FHIRBundleResponse{
+			Status:   status,
+			Location: fmt.Sprintf("Observation/%s-%d", fhirMetrics[fhirSampleCode(sample.Metric)].IDPrefix, id),
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// fhirObservationSample validates an imported Observation and converts it
+// to a sample of the user
+func fhirObservationSample(userID int, observation *models.FHIRObservation) (*importedSample, error) {
+	if observation == nil || observation.ResourceType != "Observation" {
+		return nil, fmt.Errorf("%w: entry is not an Observation", ErrInvalidFHIRResource)
+	}
+
+	if observation.Status == "entered-in-error" || observation.Status == "cancelled" {
+		return nil, fmt.Errorf("%w: status %q is not importable", ErrInvalidFHIRResource, observation.Status)
+	}
+
+	// Observations can only be imported into the caller's own record
+	if observation.Subject != nil && observation.Subject.Reference != "" {
+		patientID, err := ParseFHIRPatient(observation.Subject.Reference)
+		if err != nil || patientID != userID {
+			return nil, fmt.Errorf("%w: subject must be Patient/%d", ErrInvalidFHIRResource, userID)
+		}
+	}
+
+	var metric *fhirMetric
+	for _, coding := range observation.Code.Coding {
+		if m, ok := fhirMetrics[coding.Code]; ok && (coding.System == "" || coding.System == loincSystem) {
+			metric = &m
+			break
+		}
+	}
+	if metric == nil {
+		return nil, fmt.Errorf("%w: code must be LOINC 8867-4, 55423-8 or 41981-2", ErrInvalidFHIRResource)
+	}
+
+	if observation.ValueQuantity == nil || observation.ValueQuantity.Value == nil || *observation.ValueQuantity.Value < 0 {
+		return nil, fmt.Errorf("%w: valueQuantity.value is required", ErrInvalidFHIRResource)
+	}
+
+	// A period is attributed to its end, matching how devices report samples
+	effective := observation.EffectiveDateTime
+	if effective == "" && observation.EffectivePeriod != nil {
+		effective = observation.EffectivePeriod.End
+		if effective == "" {
+			effective = observation.EffectivePeriod.Start
+		}
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, effective)
+	if err != nil {
+		return nil, fmt.Errorf("%w: effectiveDateTime or effectivePeriod is required", ErrInvalidFHIRResource)
+	}
+
+	return &importedSample{
+		Metric:    metric.Metric,
+		Timestamp: timestamp,
+		Value:     *observation.ValueQuantity.Value,
+		Source:    models.SourceFHIR,
+	}, nil
+}
+
+// fhirSampleCode returns the LOINC code of a share metric
+func fhirSampleCode(metric string) string {
+	for code, m := range fhirMetrics {
+		if m.Metric == metric {
+			return code
+		}
+	}
+	return ""
+}
+
+// FHIROutcome builds an OperationOutcome with a single error issue
+func FHIROutcome(code, diagnostics string) *models.FHIROperationOutcome {
+	return &models.FHIROperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue: []models.FHIROperationOutcomeIssue{{
+			Severity: "error", Code: code, Diagnostics: diagnostics,
+		}},
+	}
+}
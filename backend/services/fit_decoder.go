@@ -0,0 +1,278 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FIT global message numbers and field numbers read by the importer
+const (
+	fitMessageFileID  = 0
+	fitMessageSession = 18
+	fitMessageLap     = 19
+	fitMessageRecord  = 20
+
+	fitFieldTimestamp     = 253
+	fitFieldManufacturer  = 1  // file_id
+	fitFieldHeartRate     = 3  // record: bpm
+	fitFieldDistance      = 5  // record: cumulative, 1/100 m
+	fitFieldTotalCycles   = 19 // record: cumulative strides or revolutions
+	fitFieldSport         = 5  // session
+	fitFieldTotalCalories = 11 // lap and session: kcal
+)
+
+// fitEpoch is the start of FIT timestamps, 1989-12-31T00:00:00Z
+var fitEpoch = time.Date(1989, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// fitManufacturers names the makers of common devices by their FIT
+// manufacturer ID
+var fitManufacturers = map[uint64]string{
+	1: "Garmin", 23: "Suunto", 32: "Wahoo Fitness",
+}
+
+// fitSports maps FIT sport enum values to workout types
+var fitSports = map[uint64]string{
+	0: "workout", 1: "running", 2: "cycling", 5: "swimming", 11: "walking", 17: "hiking",
+}
+
+// fitFieldDefinition is one field of a FIT definition message
+type fitFieldDefinition struct {
+	Number uint8
+	Size   uint8
+}
+
+// fitDefinition describes the layout of a local message type
+type fitDefinition struct {
+	Global    uint16
+	Order     binary.ByteOrder
+	Fields    []fitFieldDefinition
+	DevFields int // total size of developer fields, which are skipped
+}
+
+// isFITHeader reports whether a file starts with a FIT file header
+func isFITHeader(header []byte) bool {
+	return len(header) >= 12 && (header[0] == 12 || header[0] == 14) && string(header[8:12]) == ".FIT"
+}
+
+// decodeFIT reads the records, laps and sport of a FIT activity file.
+// Only the fields the importer needs are interpreted.
+func decodeFIT(r io.Reader) (*parsedActivity, error) {
+	reader := bufio.NewReader(r)
+
+	headerSize, err := reader.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+
+	header := make([]byte, int(headerSize)-1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("%w: truncated FIT header", ErrInvalidImportFile)
+	}
+	dataSize := int64(binary.LittleEndian.Uint32(header[3:7]))
+
+	definitions := make(map[uint8]*fitDefinition)
+	activity := &parsedActivity{Sport: "workout"}
+	var lastTimestamp uint32
+	var sessionCalories float64
+	var sessionEnd time.Time
+
+	read := int64(0)
+	for read < dataSize {
+		recordHeader, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated FIT data", ErrInvalidImportFile)
+		}
+		read++
+
+		var localType uint8
+		var compressedTimestamp *uint32
+
+		switch {
+		case recordHeader&0x80 != 0:
+			// Compressed timestamp header: a data message whose timestamp is
+			// a 5-bit offset from the last full timestamp
+			localType = (recordHeader >> 5) & 0x03
+			offset := uint32(recordHeader & 0x1F)
+			timestamp := (lastTimestamp &^ 0x1F) + offset
+			if offset < lastTimestamp&0x1F {
+				timestamp += 0x20
+			}
+			compressedTimestamp = &timestamp
+
+		case recordHeader&0x40 != 0:
+			definition, size, err := readFITDefinition(reader, recordHeader&0x20 != 0)
+			if err != nil {
+				return nil, err
+			}
+			definitions[recordHeader&0x0F] = definition
+			read += size
+			continue
+
+		default:
+			localType = recordHeader & 0x0F
+		}
+
+		definition, ok := definitions[localType]
+		if !ok {
+			return nil, fmt.Errorf("%w: FIT data message without a definition", ErrInvalidImportFile)
+		}
+
+		values := make(map[uint8]uint64, len(definition.Fields))
+		for _, field := range definition.Fields {
+			buf := make([]byte, field.Size)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return nil, fmt.Errorf("%w: truncated FIT data", ErrInvalidImportFile)
+			}
+			read += int64(field.Size)
+
+			if value, valid := fitUnsigned(buf, definition.Order); valid {
+				values[field.Number] = value
+			}
+		}
+		if _, err := reader.Discard(definition.DevFields); err != nil {
+			return nil, fmt.Errorf("%w: truncated FIT data", ErrInvalidImportFile)
+		}
+		read += int64(definition.DevFields)
+
+		if compressedTimestamp != nil {
+			values[fitFieldTimestamp] = uint64(*compressedTimestamp)
+		}
+		timestampValue, hasTimestamp := values[fitFieldTimestamp]
+		if hasTimestamp {
+			lastTimestamp = uint32(timestampValue)
+		}
+		timestamp := fitEpoch.Add(time.Duration(timestampValue) * time.Second)
+
+		switch definition.Global {
+		case fitMessageFileID:
+			if manufacturer, ok := values[fitFieldManufacturer]; ok {
+				activity.Device = fitManufacturers[manufacturer]
+			}
+
+		case fitMessageRecord:
+			if !hasTimestamp {
+				continue
+			}
+			point := activityPoint{Time: timestamp}
+			if heartRate, ok := values[fitFieldHeartRate]; ok {
+				point.HeartRate = nullableFloat(float64(heartRate), true)
+			}
+			if distance, ok := values[fitFieldDistance]; ok {
+				point.Distance = nullableFloat(float64(distance)/100, true)
+			}
+			if cycles, ok := values[fitFieldTotalCycles]; ok {
+				point.Steps = nullableFloat(float64(cycles), true)
+			}
+			activity.Points = append(activity.Points, point)
+
+		case fitMessageLap:
+			if calories, ok := values[fitFieldTotalCalories]; ok && hasTimestamp {
+				activity.Laps = append(activity.Laps, activityLap{End: timestamp, Calories: float64(calories)})
+			}
+
+		case fitMessageSession:
+			if sport, ok := values[fitFieldSport]; ok {
+				if name, known := fitSports[sport]; known {
+					activity.Sport = name
+				}
+			}
+			if calories, ok := values[fitFieldTotalCalories]; ok {
+				sessionCalories += float64(calories)
+				sessionEnd = timestamp
+			}
+		}
+	}
+
+	// Running and walking cycles are strides, i.e. two steps; for other
+	// sports they are pedal or stroke counts rather than steps
+	var stepsPerCycle float64
+	switch activity.Sport {
+	case "running", "walking", "hiking":
+		stepsPerCycle = 2
+	}
+	for i := range activity.Points {
+		if activity.Points[i].Steps == nil {
+			continue
+		}
+		if stepsPerCycle == 0 {
+			activity.Points[i].Steps = nil
+			continue
+		}
+		*activity.Points[i].Steps *= stepsPerCycle
+	}
+
+	// Files without laps still report the session's calories
+	if len(activity.Laps) == 0 && sessionCalories > 0 {
+		if sessionEnd.Equal(fitEpoch) && len(activity.Points) > 0 {
+			sessionEnd = activity.Points[len(activity.Points)-1].Time
+		}
+		activity.Laps = append(activity.Laps, activityLap{End: sessionEnd, Calories: sessionCalories})
+	}
+
+	return activity, nil
+}
+
+// readFITDefinition reads a definition message after its record header and
+// returns it with the number of bytes read
+func readFITDefinition(reader *bufio.Reader, hasDevFields bool) (*fitDefinition, int64, error) {
+	fixed := make([]byte, 5)
+	if _, err := io.ReadFull(reader, fixed); err != nil {
+		return nil, 0, fmt.Errorf("%w: truncated FIT definition", ErrInvalidImportFile)
+	}
+	read := int64(len(fixed))
+
+	definition := &fitDefinition{Order: binary.LittleEndian}
+	if fixed[1] == 1 {
+		definition.Order = binary.BigEndian
+	}
+	definition.Global = definition.Order.Uint16(fixed[2:4])
+
+	fieldCount := int(fixed[4])
+	fields := make([]byte, fieldCount*3)
+	if _, err := io.ReadFull(reader, fields); err != nil {
+		return nil, 0, fmt.Errorf("%w: truncated FIT definition", ErrInvalidImportFile)
+	}
+	read += int64(len(fields))
+
+	for i := 0; i < fieldCount; i++ {
+		definition.Fields = append(definition.Fields, fitFieldDefinition{Number: fields[i*3], Size: fields[i*3+1]})
+	}
+
+	if hasDevFields {
+		count, err := reader.ReadByte()
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: truncated FIT definition", ErrInvalidImportFile)
+		}
+		devFields := make([]byte, int(count)*3)
+		if _, err := io.ReadFull(reader, devFields); err != nil {
+			return nil, 0, fmt.Errorf("%w: truncated FIT definition", ErrInvalidImportFile)
+		}
+		read += 1 + int64(len(devFields))
+
+		for i := 0; i < int(count); i++ {
+			definition.DevFields += int(devFields[i*3+1])
+		}
+	}
+
+	return definition, read, nil
+}
+
+// fitUnsigned decodes a 1, 2 or 4 byte unsigned field. It reports false for
+// other sizes and for the all-ones value FIT uses to mark a missing value.
+func fitUnsigned(buf []byte, order binary.ByteOrder) (uint64, bool) {
+	switch len(buf) {
+	case 1:
+		return uint64(buf[0]), buf[0] != 0xFF
+	case 2:
+		v := order.Uint16(buf)
+		return uint64(v), v != 0xFFFF
+	case 4:
+		v := order.Uint32(buf)
+		return uint64(v), v != 0xFFFFFFFF
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// googleFitTimeLayout is the format of the interval times in Google Fit's
+// daily activity metrics, which are combined with the date in the file name
+const googleFitTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// googleFitSession is the part of a Takeout "All Sessions" file read by the
+// importer
+type googleFitSession struct {
+	FitnessActivity string `json:"fitnessActivity"`
+	StartTime       string `json:"startTime"`
+	EndTime         string `json:"endTime"`
+}
+
+// importGoogleFit imports a Google Takeout Fit export. The 15-minute rows of
+// the daily activity metrics CSVs provide steps with distance, calories and
+// average heart rate; sessions become completed workouts. Session aggregates
+// and the daily summary CSV repeat the same measurements and are ignored,
+// so each one is only counted once.
+func importGoogleFit(run *importRun, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var sessions []googleFitSession
+
+	if !isZipFile(file) {
+		// A single file from the export
+		sessions, err = importGoogleFitFile(run, run.job.FileName, file)
+		if err != nil {
+			return err
+		}
+		if sessions == nil && !isGoogleFitDailyFile(run.job.FileName) {
+			return fmt.Errorf("%w: not a Google Fit export", ErrInvalidImportFile)
+		}
+	} else {
+		archive, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+		}
+
+		var total, processed uint64
+		for _, entry := range archive.File {
+			total += entry.CompressedSize64
+		}
+
+		found := false
+		for _, entry := range archive.File {
+			processed += entry.CompressedSize64
+
+			if !isGoogleFitDailyFile(entry.Name) && !isGoogleFitSessionFile(entry.Name) {
+				continue
+			}
+			found = true
+
+			rc, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+			}
+			entrySessions, err := importGoogleFitFile(run, entry.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, entrySessions...)
+
+			if total > 0 {
+				run.progress(int64(float64(processed) / float64(total) * float64(run.job.BytesTotal)))
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: no Google Fit data found in archive", ErrInvalidImportFile)
+		}
+	}
+
+	// The samples must be stored before workouts can be linked to them
+	if err := run.flush(); err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		start, startErr := time.Parse(time.RFC3339, session.StartTime)
+		end, endErr := time.Parse(time.RFC3339, session.EndTime)
+		if startErr != nil || endErr != nil || !end.After(start) {
+			continue
+		}
+
+		if err := createImportedWorkout(run.job.UserID, googleFitSport(session.FitnessActivity), start, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importGoogleFitFile imports one file of the export, returning the session
+// it describes if it is a session file
+func importGoogleFitFile(run *importRun, name string, r io.Reader) ([]googleFitSession, error) {
+	reader := bufio.NewReader(r)
+	start, _ := reader.Peek(1)
+
+	if bytes.HasPrefix(bytes.TrimSpace(start), []byte("{")) {
+		var session googleFitSession
+		if err := json.NewDecoder(reader).Decode(&session); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidImportFile, path.Base(name), err)
+		}
+		if session.StartTime == "" {
+			return nil, nil
+		}
+		return []googleFitSession{session}, nil
+	}
+
+	if !isGoogleFitDailyFile(name) {
+		return nil, nil
+	}
+
+	date := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	return nil, importGoogleFitDailyMetrics(run, date, reader)
+}
+
+// importGoogleFitDailyMetrics imports the rows of one day's activity metrics
+func importGoogleFitDailyMetrics(run *importRun, date string, r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s.csv: %v", ErrInvalidImportFile, date, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	if _, ok := columns["Start time"]; !ok {
+		return fmt.Errorf("%w: %s.csv has no Start time column", ErrInvalidImportFile, date)
+	}
+
+	value := func(record []string, column string) (float64, bool) {
+		i, ok := columns[column]
+		if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+		return v, err == nil && v >= 0
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s.csv: %v", ErrInvalidImportFile, date, err)
+		}
+
+		i := columns["Start time"]
+		if i >= len(record) {
+			continue
+		}
+		start, err := time.Parse(googleFitTimeLayout, date+"T"+record[i])
+		if err != nil {
+			continue
+		}
+		end := start.Add(15 * time.Minute)
+		if i, ok := columns["End time"]; ok && i < len(record) {
+			if parsed, err := time.Parse(googleFitTimeLayout, date+"T"+record[i]); err == nil && parsed.After(start) {
+				end = parsed
+			}
+		}
+
+		// Heart rate is an instant; steps and energy cover the interval and
+		// are attributed to its end, as with Apple Health records
+		if heartRate, ok := value(record, "Average heart rate (bpm)"); ok && heartRate > 0 {
+			err := run.add(importedSample{Metric: models.ShareMetricHeartRate, Timestamp: start, Value: heartRate})
+			if err != nil {
+				return err
+			}
+		}
+
+		steps, hasSteps := value(record, "Step count")
+		distance, hasDistance := value(record, "Distance (m)")
+		if (hasSteps && steps > 0) || (hasDistance && distance > 0) {
+			sample := importedSample{Metric: models.ShareMetricSteps, Timestamp: end, Value: steps}
+			if hasDistance {
+				sample.Distance = &distance
+			}
+			if err := run.add(sample); err != nil {
+				return err
+			}
+		}
+
+		if calories, ok := value(record, "Calories (kcal)"); ok && calories > 0 {
+			err := run.add(importedSample{Metric: models.ShareMetricCalories, Timestamp: end, Value: calories})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isGoogleFitDailyFile reports whether a file is one day's activity metrics,
+// named for its date, rather than the daily summary
+func isGoogleFitDailyFile(name string) bool {
+	base := path.Base(name)
+	if path.Ext(base) != ".csv" {
+		return false
+	}
+	_, err := time.Parse("2006-01-02", strings.TrimSuffix(base, ".csv"))
+	return err == nil
+}
+
+// isGoogleFitSessionFile reports whether a file is from the export's
+// sessions folder
+func isGoogleFitSessionFile(name string) bool {
+	dir := strings.ToLower(path.Base(path.Dir(name)))
+	return dir == "all sessions" && path.Ext(name) == ".json"
+}
+
+// googleFitSport maps a Google Fit activity such as "running.jogging" to a
+// workout type
+func googleFitSport(activity string) string {
+	sport, _, _ := strings.Cut(activity, ".")
+	return activitySport(sport)
+}
@@ -4,8 +4,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/habdil/notify-vital/backend/config"
 	"github.com/habdil/notify-vital/backend/models"
 )
@@ -16,7 +20,7 @@ func GetHealthDataForUser(userID int) (*models.HealthData, error) {
 
 	query := `
 		SELECT data_id, user_id, device_id, timestamp, heart_rate, steps, calories_burned, 
-		       activity_status, activity_gauge_value, created_at 
+		       activity_status, activity_gauge_value, source, created_at 
 		FROM health_data 
 		WHERE user_id = $1 
 		ORDER BY timestamp DESC 
@@ -31,7 +35,7 @@ func GetHealthDataForUser(userID int) (*models.HealthData, error) {
 	err := config.DB.QueryRow(query, userID).Scan(
 		&healthData.DataID, &healthData.UserID, &deviceID, &healthData.Timestamp,
 		&heartRate, &steps, &caloriesBurned, &healthData.ActivityStatus,
-		&healthData.ActivityGaugeValue, &healthData.CreatedAt,
+		&healthData.ActivityGaugeValue, &healthData.Source, &healthData.CreatedAt,
 	)
 
 	if err != nil {
@@ -69,39 +73,33 @@ func GetHealthDataForUser(userID int) (*models.HealthData, error) {
 func GetHealthDataHistory(userID int, filters models.HealthDataFilters) ([]models.HealthData, error) {
 	var healthDataList []models.HealthData
 
+	err := StreamHealthDataHistory(userID, filters, func(healthData models.HealthData) error {
+		healthDataList = append(healthDataList, healthData)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return healthDataList, nil
+}
+
+// StreamHealthDataHistory calls fn for each health data row of a user as it
+// is read from the database, newest first
+func StreamHealthDataHistory(userID int, filters models.HealthDataFilters, fn func(models.HealthData) error) error {
 	// Base query
 	query := `
 		SELECT data_id, user_id, device_id, timestamp, heart_rate, steps, calories_burned, 
-		       activity_status, activity_gauge_value, created_at 
+		       activity_status, activity_gauge_value, source, created_at 
 		FROM health_data 
 		WHERE user_id = $1
 	`
 
-	// Apply date filters if provided
-	args := []interface{}{userID}
-	argCount := 2
-
-	if filters.StartDate != "" {
-		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
-		args = append(args, filters.StartDate)
-		argCount++
-	}
-
-	if filters.EndDate != "" {
-		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
-		args = append(args, filters.EndDate)
-		argCount++
-	}
-
-	// Add order, limit and offset
-	query += " ORDER BY timestamp DESC LIMIT $" + fmt.Sprintf("%d", argCount) +
-		" OFFSET $" + fmt.Sprintf("%d", argCount+1)
-
-	args = append(args, filters.Limit, filters.Offset)
+	query, args := applyHistoryFilters(query, []interface{}{userID}, filters)
 
 	rows, err := config.DB.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
@@ -115,11 +113,11 @@ func GetHealthDataHistory(userID int, filters models.HealthDataFilters) ([]model
 		err := rows.Scan(
 			&healthData.DataID, &healthData.UserID, &deviceID, &healthData.Timestamp,
 			&heartRate, &steps, &caloriesBurned, &healthData.ActivityStatus,
-			&healthData.ActivityGaugeValue, &healthData.CreatedAt,
+			&healthData.ActivityGaugeValue, &healthData.Source, &healthData.CreatedAt,
 		)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// Handle null values
@@ -143,14 +141,63 @@ func GetHealthDataHistory(userID int, filters models.HealthDataFilters) ([]model
 			healthData.CaloriesBurned = &caloriesBurnedInt
 		}
 
-		healthDataList = append(healthDataList, healthData)
+		if err := fn(healthData); err != nil {
+			return err
+		}
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	return rows.Err()
+}
+
+// applyHistoryFilters adds the date range, sources, order and page of a
+// history query to a query selecting one user's rows. A zero limit selects
+// the whole range.
+func applyHistoryFilters(query string, args []interface{}, filters models.HealthDataFilters) (string, []interface{}) {
+	argCount := len(args) + 1
+
+	if filters.StartDate != "" {
+		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
+		args = append(args, filters.StartDate)
+		argCount++
 	}
 
-	return healthDataList, nil
+	if filters.EndDate != "" {
+		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
+		args = append(args, filters.EndDate)
+		argCount++
+	}
+
+	if filters.Source != "" {
+		sources := strings.Split(filters.Source, ",")
+		for i := range sources {
+			sources[i] = strings.TrimSpace(sources[i])
+		}
+
+		query += fmt.Sprintf(" AND source = ANY($%d)", argCount)
+		args = append(args, pq.Array(sources))
+		argCount++
+	}
+
+	query += " ORDER BY timestamp DESC"
+
+	if filters.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", argCount, argCount+1)
+		args = append(args, filters.Limit, filters.Offset)
+	}
+
+	return query, args
+}
+
+// recordSource returns the source of a recorded sample: the one the client
+// gave, otherwise device when it names a device and manual entry when not
+func recordSource(source string, deviceID *int) string {
+	if source != "" {
+		return source
+	}
+	if deviceID != nil {
+		return models.SourceDevice
+	}
+	return models.SourceManual
 }
 
 // CreateHealthData creates a new health data entry
@@ -158,8 +205,8 @@ func CreateHealthData(userID int, data models.HealthDataRequest) (*models.Health
 	query := `
 		INSERT INTO health_data (
 			user_id, device_id, timestamp, heart_rate, steps, 
-			calories_burned, activity_status, activity_gauge_value
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			calories_burned, activity_status, activity_gauge_value, source
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING data_id, timestamp, created_at
 	`
 
@@ -171,6 +218,7 @@ func CreateHealthData(userID int, data models.HealthDataRequest) (*models.Health
 	healthData.CaloriesBurned = data.CaloriesBurned
 	healthData.ActivityStatus = data.ActivityStatus
 	healthData.ActivityGaugeValue = data.ActivityGaugeValue
+	healthData.Source = recordSource(data.Source, data.DeviceID)
 
 	now := time.Now()
 
@@ -184,6 +232,7 @@ func CreateHealthData(userID int, data models.HealthDataRequest) (*models.Health
 		data.CaloriesBurned,
 		data.ActivityStatus,
 		data.ActivityGaugeValue,
+		healthData.Source,
 	).Scan(&healthData.DataID, &healthData.Timestamp, &healthData.CreatedAt)
 
 	if err != nil {
@@ -230,6 +279,39 @@ func GetHealthDataSummary(userID int, startDate, endDate string) (*models.Health
 		return nil, err
 	}
 
+	// Samples recorded per metric take precedence over the combined records,
+	// counting only the preferred source where several overlap
+	metricTotals := []struct {
+		table, aggregate string
+		dest             interface{}
+	}{
+		{"heart_rate_data", "AVG(heart_rate)", &summary.AverageHeartRate},
+		{"steps_data", "SUM(steps_count)", &summary.TotalSteps},
+		{"calories_data", "SUM(calories_burned)", &summary.TotalCaloriesBurned},
+	}
+
+	for _, metric := range metricTotals {
+		metricQuery, metricArgs := preferredSourceQuery(
+			metric.table, "COUNT(*), COALESCE("+metric.aggregate+", 0)", "", userID, startDate, endDate,
+		)
+
+		var count int
+		var total float64
+		if err := config.DB.QueryRow(metricQuery, metricArgs...).Scan(&count, &total); err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			continue
+		}
+		switch dest := metric.dest.(type) {
+		case *float64:
+			*dest = total
+		case *int:
+			*dest = int(math.Round(total))
+		}
+	}
+
 	// Get activity distribution
 	activityQuery := `
 		SELECT 
@@ -273,6 +355,22 @@ func GetHealthDataSummary(userID int, startDate, endDate string) (*models.Health
 		return nil, err
 	}
 
+	// Total distance, derived from steps where the device didn't report it
+	profile, err := loadStrideProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	distanceQuery, distanceArgs := preferredSourceQuery(
+		"steps_data", "steps_count, distance, interval_seconds",
+		stepsIntervalColumn+" AS interval_seconds", userID, startDate, endDate,
+	)
+
+	summary.TotalDistance, err = sumStepsDistance(config.DB, profile, distanceQuery, distanceArgs...)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse dates for period information
 	if startDate != "" {
 		parsedStart, err := time.Parse("2006-01-02", startDate)
@@ -295,35 +393,31 @@ func GetHealthDataSummary(userID int, startDate, endDate string) (*models.Health
 func GetHeartRateHistory(userID int, filters models.HealthDataFilters) ([]models.HeartRateData, error) {
 	var heartRateDataList []models.HeartRateData
 
+	err := StreamHeartRateHistory(userID, filters, func(heartRateData models.HeartRateData) error {
+		heartRateDataList = append(heartRateDataList, heartRateData)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return heartRateDataList, nil
+}
+
+// StreamHeartRateHistory calls fn for each heart rate sample of a user as it
+// is read from the database, newest first
+func StreamHeartRateHistory(userID int, filters models.HealthDataFilters, fn func(models.HeartRateData) error) error {
 	query := `
-		SELECT id, user_id, device_id, timestamp, heart_rate, activity_type, created_at
+		SELECT id, user_id, device_id, timestamp, heart_rate, activity_type, workout_id, source, created_at
 		FROM heart_rate_data
 		WHERE user_id = $1
 	`
 
-	args := []interface{}{userID}
-	argCount := 2
-
-	if filters.StartDate != "" {
-		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
-		args = append(args, filters.StartDate)
-		argCount++
-	}
-
-	if filters.EndDate != "" {
-		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
-		args = append(args, filters.EndDate)
-		argCount++
-	}
-
-	query += " ORDER BY timestamp DESC LIMIT $" + fmt.Sprintf("%d", argCount) +
-		" OFFSET $" + fmt.Sprintf("%d", argCount+1)
-
-	args = append(args, filters.Limit, filters.Offset)
+	query, args := applyHistoryFilters(query, []interface{}{userID}, filters)
 
 	rows, err := config.DB.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
@@ -331,14 +425,16 @@ func GetHeartRateHistory(userID int, filters models.HealthDataFilters) ([]models
 		var heartRateData models.HeartRateData
 		var deviceID sql.NullInt32
 		var activityType sql.NullString
+		var workoutID sql.NullInt32
 
 		err := rows.Scan(
 			&heartRateData.ID, &heartRateData.UserID, &deviceID, &heartRateData.Timestamp,
-			&heartRateData.HeartRate, &activityType, &heartRateData.CreatedAt,
+			&heartRateData.HeartRate, &activityType, &workoutID, &heartRateData.Source,
+			&heartRateData.CreatedAt,
 		)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if deviceID.Valid {
@@ -351,22 +447,25 @@ func GetHeartRateHistory(userID int, filters models.HealthDataFilters) ([]models
 			heartRateData.ActivityType = &activityTypeStr
 		}
 
-		heartRateDataList = append(heartRateDataList, heartRateData)
-	}
+		if workoutID.Valid {
+			workoutIDInt := int(workoutID.Int32)
+			heartRateData.WorkoutID = &workoutIDInt
+		}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+		if err := fn(heartRateData); err != nil {
+			return err
+		}
 	}
 
-	return heartRateDataList, nil
+	return rows.Err()
 }
 
 // CreateHeartRateData adds a new heart rate data entry
 func CreateHeartRateData(userID int, data models.HeartRateRequest) (*models.HeartRateData, error) {
 	query := `
 		INSERT INTO heart_rate_data (
-			user_id, device_id, timestamp, heart_rate, activity_type
-		) VALUES ($1, $2, $3, $4, $5)
+			user_id, device_id, timestamp, heart_rate, activity_type, source
+		) VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, timestamp, created_at
 	`
 
@@ -375,6 +474,7 @@ func CreateHeartRateData(userID int, data models.HeartRateRequest) (*models.Hear
 	heartRateData.DeviceID = data.DeviceID
 	heartRateData.HeartRate = data.HeartRate
 	heartRateData.ActivityType = data.ActivityType
+	heartRateData.Source = recordSource(data.Source, data.DeviceID)
 
 	now := time.Now()
 
@@ -385,6 +485,7 @@ func CreateHeartRateData(userID int, data models.HeartRateRequest) (*models.Hear
 		now,
 		data.HeartRate,
 		data.ActivityType,
+		heartRateData.Source,
 	).Scan(&heartRateData.ID, &heartRateData.Timestamp, &heartRateData.CreatedAt)
 
 	if err != nil {
@@ -401,35 +502,55 @@ func CreateHeartRateData(userID int, data models.HeartRateRequest) (*models.Hear
 func GetStepsHistory(userID int, filters models.HealthDataFilters) ([]models.StepsData, error) {
 	var stepsDataList []models.StepsData
 
-	query := `
-		SELECT id, user_id, device_id, timestamp, steps_count, distance, created_at
-		FROM steps_data
-		WHERE user_id = $1
-	`
+	err := StreamStepsHistory(userID, filters, func(stepsData models.StepsData) error {
+		stepsDataList = append(stepsDataList, stepsData)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	return stepsDataList, nil
+}
+
+// StreamStepsHistory calls fn for each steps sample of a user as it is read
+// from the database, newest first
+func StreamStepsHistory(userID int, filters models.HealthDataFilters, fn func(models.StepsData) error) error {
+	profile, err := loadStrideProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	// The interval since the previous sample gives the cadence used to pick a
+	// stride; it is only computed over the requested range
 	args := []interface{}{userID}
-	argCount := 2
+	window := ""
 
 	if filters.StartDate != "" {
-		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
 		args = append(args, filters.StartDate)
-		argCount++
+		window += " AND " + stepsIntervalFrom(fmt.Sprintf("$%d", len(args)))
 	}
 
 	if filters.EndDate != "" {
-		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
 		args = append(args, filters.EndDate)
-		argCount++
+		window += fmt.Sprintf(" AND timestamp <= $%d", len(args))
 	}
 
-	query += " ORDER BY timestamp DESC LIMIT $" + fmt.Sprintf("%d", argCount) +
-		" OFFSET $" + fmt.Sprintf("%d", argCount+1)
+	query := `
+		SELECT id, user_id, device_id, timestamp, steps_count, distance, workout_id, source, created_at, interval_seconds
+		FROM (
+			SELECT *, ` + stepsIntervalColumn + ` AS interval_seconds
+			FROM steps_data
+			WHERE user_id = $1` + window + `
+		) steps
+		WHERE user_id = $1
+	`
 
-	args = append(args, filters.Limit, filters.Offset)
+	query, args = applyHistoryFilters(query, args, filters)
 
 	rows, err := config.DB.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
@@ -437,14 +558,17 @@ func GetStepsHistory(userID int, filters models.HealthDataFilters) ([]models.Ste
 		var stepsData models.StepsData
 		var deviceID sql.NullInt32
 		var distance sql.NullFloat64
+		var workoutID sql.NullInt32
+		var intervalSeconds *float64
 
 		err := rows.Scan(
 			&stepsData.ID, &stepsData.UserID, &deviceID, &stepsData.Timestamp,
-			&stepsData.StepsCount, &distance, &stepsData.CreatedAt,
+			&stepsData.StepsCount, &distance, &workoutID, &stepsData.Source,
+			&stepsData.CreatedAt, &intervalSeconds,
 		)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if deviceID.Valid {
@@ -455,24 +579,31 @@ func GetStepsHistory(userID int, filters models.HealthDataFilters) ([]models.Ste
 		if distance.Valid {
 			distanceFloat := distance.Float64
 			stepsData.Distance = &distanceFloat
+		} else if profile != nil {
+			distanceFloat := profile.distance(stepsData.StepsCount, intervalSeconds)
+			stepsData.Distance = &distanceFloat
+			stepsData.DistanceEstimated = true
 		}
 
-		stepsDataList = append(stepsDataList, stepsData)
-	}
+		if workoutID.Valid {
+			workoutIDInt := int(workoutID.Int32)
+			stepsData.WorkoutID = &workoutIDInt
+		}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+		if err := fn(stepsData); err != nil {
+			return err
+		}
 	}
 
-	return stepsDataList, nil
+	return rows.Err()
 }
 
 // CreateStepsData adds a new steps data entry
 func CreateStepsData(userID int, data models.StepsRequest) (*models.StepsData, error) {
 	query := `
 		INSERT INTO steps_data (
-			user_id, device_id, timestamp, steps_count, distance
-		) VALUES ($1, $2, $3, $4, $5)
+			user_id, device_id, timestamp, steps_count, distance, source
+		) VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, timestamp, created_at
 	`
 
@@ -481,6 +612,7 @@ func CreateStepsData(userID int, data models.StepsRequest) (*models.StepsData, e
 	stepsData.DeviceID = data.DeviceID
 	stepsData.StepsCount = data.StepsCount
 	stepsData.Distance = data.Distance
+	stepsData.Source = recordSource(data.Source, data.DeviceID)
 
 	now := time.Now()
 
@@ -491,6 +623,7 @@ func CreateStepsData(userID int, data models.StepsRequest) (*models.StepsData, e
 		now,
 		data.StepsCount,
 		data.Distance,
+		stepsData.Source,
 	).Scan(&stepsData.ID, &stepsData.Timestamp, &stepsData.CreatedAt)
 
 	if err != nil {
@@ -504,35 +637,31 @@ func CreateStepsData(userID int, data models.StepsRequest) (*models.StepsData, e
 func GetCaloriesHistory(userID int, filters models.HealthDataFilters) ([]models.CaloriesData, error) {
 	var caloriesDataList []models.CaloriesData
 
+	err := StreamCaloriesHistory(userID, filters, func(caloriesData models.CaloriesData) error {
+		caloriesDataList = append(caloriesDataList, caloriesData)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return caloriesDataList, nil
+}
+
+// StreamCaloriesHistory calls fn for each calories sample of a user as it is
+// read from the database, newest first
+func StreamCaloriesHistory(userID int, filters models.HealthDataFilters, fn func(models.CaloriesData) error) error {
 	query := `
-		SELECT id, user_id, device_id, timestamp, calories_burned, activity_type, created_at
+		SELECT id, user_id, device_id, timestamp, calories_burned, activity_type, workout_id, is_estimated, source, created_at
 		FROM calories_data
 		WHERE user_id = $1
 	`
 
-	args := []interface{}{userID}
-	argCount := 2
-
-	if filters.StartDate != "" {
-		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
-		args = append(args, filters.StartDate)
-		argCount++
-	}
-
-	if filters.EndDate != "" {
-		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
-		args = append(args, filters.EndDate)
-		argCount++
-	}
-
-	query += " ORDER BY timestamp DESC LIMIT $" + fmt.Sprintf("%d", argCount) +
-		" OFFSET $" + fmt.Sprintf("%d", argCount+1)
-
-	args = append(args, filters.Limit, filters.Offset)
+	query, args := applyHistoryFilters(query, []interface{}{userID}, filters)
 
 	rows, err := config.DB.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
@@ -540,14 +669,16 @@ func GetCaloriesHistory(userID int, filters models.HealthDataFilters) ([]models.
 		var caloriesData models.CaloriesData
 		var deviceID sql.NullInt32
 		var activityType sql.NullString
+		var workoutID sql.NullInt32
 
 		err := rows.Scan(
 			&caloriesData.ID, &caloriesData.UserID, &deviceID, &caloriesData.Timestamp,
-			&caloriesData.CaloriesBurned, &activityType, &caloriesData.CreatedAt,
+			&caloriesData.CaloriesBurned, &activityType, &workoutID, &caloriesData.IsEstimated,
+			&caloriesData.Source, &caloriesData.CreatedAt,
 		)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if deviceID.Valid {
@@ -560,22 +691,25 @@ func GetCaloriesHistory(userID int, filters models.HealthDataFilters) ([]models.
 			caloriesData.ActivityType = &activityTypeStr
 		}
 
-		caloriesDataList = append(caloriesDataList, caloriesData)
-	}
+		if workoutID.Valid {
+			workoutIDInt := int(workoutID.Int32)
+			caloriesData.WorkoutID = &workoutIDInt
+		}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+		if err := fn(caloriesData); err != nil {
+			return err
+		}
 	}
 
-	return caloriesDataList, nil
+	return rows.Err()
 }
 
 // CreateCaloriesData adds a new calories data entry
 func CreateCaloriesData(userID int, data models.CaloriesRequest) (*models.CaloriesData, error) {
 	query := `
 		INSERT INTO calories_data (
-			user_id, device_id, timestamp, calories_burned, activity_type
-		) VALUES ($1, $2, $3, $4, $5)
+			user_id, device_id, timestamp, calories_burned, activity_type, source
+		) VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, timestamp, created_at
 	`
 
@@ -584,9 +718,15 @@ func CreateCaloriesData(userID int, data models.CaloriesRequest) (*models.Calori
 	caloriesData.DeviceID = data.DeviceID
 	caloriesData.CaloriesBurned = data.CaloriesBurned
 	caloriesData.ActivityType = data.ActivityType
+	caloriesData.Source = recordSource(data.Source, data.DeviceID)
 
 	now := time.Now()
 
+	// Reported calories replace any estimate for the same window
+	if err := clearEstimatedCalories(userID, now); err != nil {
+		return nil, err
+	}
+
 	err := config.DB.QueryRow(
 		query,
 		userID,
@@ -594,6 +734,7 @@ func CreateCaloriesData(userID int, data models.CaloriesRequest) (*models.Calori
 		now,
 		data.CaloriesBurned,
 		data.ActivityType,
+		caloriesData.Source,
 	).Scan(&caloriesData.ID, &caloriesData.Timestamp, &caloriesData.CreatedAt)
 
 	if err != nil {
@@ -607,35 +748,31 @@ func CreateCaloriesData(userID int, data models.CaloriesRequest) (*models.Calori
 func GetActivityStatusHistory(userID int, filters models.HealthDataFilters) ([]models.ActivityStatusUpdate, error) {
 	var statusUpdatesList []models.ActivityStatusUpdate
 
+	err := StreamActivityStatusHistory(userID, filters, func(statusUpdate models.ActivityStatusUpdate) error {
+		statusUpdatesList = append(statusUpdatesList, statusUpdate)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statusUpdatesList, nil
+}
+
+// StreamActivityStatusHistory calls fn for each activity status update of a
+// user as it is read from the database, newest first
+func StreamActivityStatusHistory(userID int, filters models.HealthDataFilters, fn func(models.ActivityStatusUpdate) error) error {
 	query := `
-		SELECT id, user_id, timestamp, previous_status, current_status, status_change_reason, created_at
+		SELECT id, user_id, timestamp, previous_status, current_status, status_change_reason, source, created_at
 		FROM activity_status_updates
 		WHERE user_id = $1
 	`
 
-	args := []interface{}{userID}
-	argCount := 2
-
-	if filters.StartDate != "" {
-		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
-		args = append(args, filters.StartDate)
-		argCount++
-	}
-
-	if filters.EndDate != "" {
-		query += fmt.Sprintf(" AND timestamp <= $%d", argCount)
-		args = append(args, filters.EndDate)
-		argCount++
-	}
-
-	query += " ORDER BY timestamp DESC LIMIT $" + fmt.Sprintf("%d", argCount) +
-		" OFFSET $" + fmt.Sprintf("%d", argCount+1)
-
-	args = append(args, filters.Limit, filters.Offset)
+	query, args := applyHistoryFilters(query, []interface{}{userID}, filters)
 
 	rows, err := config.DB.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
@@ -647,11 +784,11 @@ func GetActivityStatusHistory(userID int, filters models.HealthDataFilters) ([]m
 		err := rows.Scan(
 			&statusUpdate.ID, &statusUpdate.UserID, &statusUpdate.Timestamp,
 			&previousStatus, &statusUpdate.CurrentStatus, &statusChangeReason,
-			&statusUpdate.CreatedAt,
+			&statusUpdate.Source, &statusUpdate.CreatedAt,
 		)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if previousStatus.Valid {
@@ -664,22 +801,20 @@ func GetActivityStatusHistory(userID int, filters models.HealthDataFilters) ([]m
 			statusUpdate.StatusChangeReason = &reasonStr
 		}
 
-		statusUpdatesList = append(statusUpdatesList, statusUpdate)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, err
+		if err := fn(statusUpdate); err != nil {
+			return err
+		}
 	}
 
-	return statusUpdatesList, nil
+	return rows.Err()
 }
 
 // CreateActivityStatusUpdate adds a new activity status update
 func CreateActivityStatusUpdate(userID int, data models.ActivityStatusRequest) (*models.ActivityStatusUpdate, error) {
 	query := `
 		INSERT INTO activity_status_updates (
-			user_id, timestamp, previous_status, current_status, status_change_reason
-		) VALUES ($1, $2, $3, $4, $5)
+			user_id, timestamp, previous_status, current_status, status_change_reason, source
+		) VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, timestamp, created_at
 	`
 
@@ -688,6 +823,7 @@ func CreateActivityStatusUpdate(userID int, data models.ActivityStatusRequest) (
 	statusUpdate.PreviousStatus = data.PreviousStatus
 	statusUpdate.CurrentStatus = data.CurrentStatus
 	statusUpdate.StatusChangeReason = data.StatusChangeReason
+	statusUpdate.Source = recordSource(data.Source, nil)
 
 	now := time.Now()
 
@@ -698,6 +834,7 @@ func CreateActivityStatusUpdate(userID int, data models.ActivityStatusRequest) (
 		data.PreviousStatus,
 		data.CurrentStatus,
 		data.StatusChangeReason,
+		statusUpdate.Source,
 	).Scan(&statusUpdate.ID, &statusUpdate.Timestamp, &statusUpdate.CreatedAt)
 
 	if err != nil {
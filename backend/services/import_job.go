@@ -0,0 +1,483 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const (
+	// importBatchSize is how many samples are inserted per statement
+	importBatchSize = 2000
+
+	// importProgressInterval limits how often progress is written to the job
+	importProgressInterval = 2 * time.Second
+
+	// importTimestampLayout formats times in timestamp array parameters
+	importTimestampLayout = "2006-01-02 15:04:05.999999"
+)
+
+var (
+	ErrImportNotFound    = errors.New("import not found")
+	ErrImportInProgress  = errors.New("an import is already in progress")
+	ErrImportTooLarge    = errors.New("import file is too large")
+	ErrUnsupportedImport = errors.New("unsupported import kind")
+	ErrInvalidImportFile = errors.New("file is not a valid export")
+)
+
+// importers parse an uploaded file of each kind, passing samples to the run
+var importers = map[string]func(run *importRun, file *os.File) error{
+	models.ImportKindAppleHealth:  importAppleHealth,
+	models.ImportKindActivityFile: importActivityFile,
+	models.ImportKindGoogleFit:    importGoogleFit,
+}
+
+// importDir returns the directory uploaded files are kept in while imported
+func importDir() string {
+	return config.GetEnv("IMPORT_DIR", "imports")
+}
+
+// maxImportSize returns the largest file of a kind that can be uploaded, in
+// bytes. Activity files are decoded in memory, so their limit is far lower
+// than for the streamed exports.
+func maxImportSize(kind string) int64 {
+	if kind == models.ImportKindActivityFile {
+		return int64(config.GetEnvInt("ACTIVITY_IMPORT_MAX_SIZE_MB", 50)) << 20
+	}
+	return int64(config.GetEnvInt("IMPORT_MAX_SIZE_MB", 2048)) << 20
+}
+
+// StartImport saves an uploaded file and imports it in the background. Only
+// one import per user runs at a time.
+func StartImport(userID int, kind, fileName string, file io.Reader) (*models.ImportJob, error) {
+	if _, ok := importers[kind]; !ok {
+		return nil, ErrUnsupportedImport
+	}
+
+	var inProgress bool
+	err := config.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM import_jobs WHERE user_id = $1 AND status IN ($2, $3))",
+		userID, models.ImportStatusPending, models.ImportStatusRunning,
+	).Scan(&inProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	if inProgress {
+		return nil, ErrImportInProgress
+	}
+
+	if err := os.MkdirAll(importDir(), 0o700); err != nil {
+		return nil, err
+	}
+
+	out, err := os.CreateTemp(importDir(), fmt.Sprintf("import-%d-*", userID))
+	if err != nil {
+		return nil, err
+	}
+	path := out.Name()
+
+	// Read one byte past the limit to tell a full-size file from a larger one
+	maxSize := maxImportSize(kind)
+	size, err := io.Copy(out, io.LimitReader(file, maxSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxSize {
+		err = ErrImportTooLarge
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	job := models.ImportJob{
+		UserID:     userID,
+		Kind:       kind,
+		Status:     models.ImportStatusPending,
+		FileName:   sanitizeFileName(filepath.Base(fileName)),
+		BytesTotal: size,
+		FilePath:   path,
+	}
+
+	err = config.DB.QueryRow(
+		`INSERT INTO import_jobs (user_id, kind, status, file_name, file_path, bytes_total)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		userID, kind, job.Status, job.FileName, path, size,
+	).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	go runImport(job)
+
+	return &job, nil
+}
+
+// importRun is the state of a running import, fed samples by the parser
+type importRun struct {
+	job          models.ImportJob
+	pending      []importedSample
+	lastProgress time.Time
+}
+
+// add queues a parsed sample, inserting the queue once it is full. Samples
+// are tagged with the import's kind as their source.
+func (r *importRun) add(sample importedSample) error {
+	r.job.RecordsFound++
+	if sample.Source == "" {
+		sample.Source = r.job.Kind
+	}
+	r.pending = append(r.pending, sample)
+
+	if len(r.pending) >= importBatchSize {
+		return r.flush()
+	}
+	return nil
+}
+
+// flush inserts the queued samples
+func (r *importRun) flush() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	created, err := storeImportedSamples(tx, r.job.UserID, &r.job.ID, r.pending)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	r.job.RecordsImported += created
+	r.job.RecordsSkipped += len(r.pending) - created
+	r.pending = r.pending[:0]
+	return nil
+}
+
+// progress records how much of the file has been read. Updates are
+// throttled, so it can be called for every record.
+func (r *importRun) progress(bytesProcessed int64) {
+	r.job.BytesProcessed = bytesProcessed
+
+	if time.Since(r.lastProgress) < importProgressInterval {
+		return
+	}
+	r.lastProgress = time.Now()
+
+	_, err := config.DB.Exec(
+		`UPDATE import_jobs SET bytes_processed = $1, records_found = $2, records_imported = $3, records_skipped = $4
+		 WHERE id = $5`,
+		r.job.BytesProcessed, r.job.RecordsFound, r.job.RecordsImported, r.job.RecordsSkipped, r.job.ID,
+	)
+	if err != nil {
+		log.Printf("Failed to record progress of import %d: %v", r.job.ID, err)
+	}
+}
+
+// FailInterruptedImports marks imports left pending or running by a
+// previous server process as failed and deletes their uploaded files, so
+// they don't block new imports. It is called once at startup.
+func FailInterruptedImports() {
+	rows, err := config.DB.Query(
+		"SELECT id, file_path FROM import_jobs WHERE status IN ($1, $2)",
+		models.ImportStatusPending, models.ImportStatusRunning,
+	)
+	if err != nil {
+		log.Printf("Failed to find interrupted imports: %v", err)
+		return
+	}
+
+	var interrupted []int
+	for rows.Next() {
+		var id int
+		var filePath sql.NullString
+		if err := rows.Scan(&id, &filePath); err != nil {
+			log.Printf("Failed to read interrupted import: %v", err)
+			break
+		}
+
+		if filePath.Valid {
+			if err := os.Remove(filePath.String); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to delete import file %s: %v", filePath.String, err)
+			}
+		}
+		interrupted = append(interrupted, id)
+	}
+	rows.Close()
+
+	for _, id := range interrupted {
+		_, err := config.DB.Exec(
+			"UPDATE import_jobs SET status = $1, error = $2, file_path = NULL, completed_at = $3 WHERE id = $4",
+			models.ImportStatusFailed, "interrupted by a server restart", time.Now(), id,
+		)
+		if err != nil {
+			log.Printf("Failed to fail interrupted import %d: %v", id, err)
+		}
+	}
+}
+
+// runImport parses the job's file and records the outcome. The uploaded
+// file is deleted afterwards either way.
+func runImport(job models.ImportJob) {
+	defer func() {
+		if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete import file %s: %v", job.FilePath, err)
+		}
+	}()
+
+	_, err := config.DB.Exec(
+		"UPDATE import_jobs SET status = $1, started_at = $2 WHERE id = $3",
+		models.ImportStatusRunning, time.Now(), job.ID,
+	)
+	if err != nil {
+		log.Printf("Failed to start import %d: %v", job.ID, err)
+		return
+	}
+
+	run := &importRun{job: job}
+
+	err = func() error {
+		file, err := os.Open(job.FilePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err := importers[job.Kind](run, file); err != nil {
+			return err
+		}
+		return run.flush()
+	}()
+
+	status := models.ImportStatusCompleted
+	var errorMessage *string
+	if err != nil {
+		log.Printf("Import %d failed: %v", job.ID, err)
+		status = models.ImportStatusFailed
+		message := err.Error()
+		errorMessage = &message
+	} else {
+		run.job.BytesProcessed = run.job.BytesTotal
+	}
+
+	_, err = config.DB.Exec(
+		`UPDATE import_jobs
+		 SET status = $1, error = $2, bytes_processed = $3, records_found = $4, records_imported = $5,
+		     records_skipped = $6, file_path = NULL, completed_at = $7
+		 WHERE id = $8`,
+		status, errorMessage, run.job.BytesProcessed, run.job.RecordsFound, run.job.RecordsImported,
+		run.job.RecordsSkipped, time.Now(), job.ID,
+	)
+	if err != nil {
+		log.Printf("Failed to record outcome of import %d: %v", job.ID, err)
+	}
+}
+
+// importJobColumns are the import_jobs columns read by scanImportJob
+const importJobColumns = `id, user_id, kind, status, file_name, bytes_total, bytes_processed, records_found,
+	records_imported, records_skipped, error, created_at, started_at, completed_at`
+
+// scanImportJob reads an import job selected with importJobColumns
+func scanImportJob(row rowScanner) (*models.ImportJob, error) {
+	var job models.ImportJob
+	var errorMessage sql.NullString
+	var startedAt, completedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID, &job.UserID, &job.Kind, &job.Status, &job.FileName, &job.BytesTotal, &job.BytesProcessed,
+		&job.RecordsFound, &job.RecordsImported, &job.RecordsSkipped, &errorMessage, &job.CreatedAt,
+		&startedAt, &completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Error = errorMessage.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	if job.BytesTotal > 0 {
+		job.Progress = float64(job.BytesProcessed) / float64(job.BytesTotal)
+	}
+
+	return &job, nil
+}
+
+// GetImportJob returns one of the user's import jobs
+func GetImportJob(userID, jobID int) (*models.ImportJob, error) {
+	job, err := scanImportJob(config.DB.QueryRow(
+		"SELECT "+importJobColumns+" FROM import_jobs WHERE id = $1 AND user_id = $2",
+		jobID, userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrImportNotFound
+	}
+	return job, err
+}
+
+// GetImportJobs lists the user's import jobs, newest first
+func GetImportJobs(userID int) ([]models.ImportJob, error) {
+	rows, err := config.DB.Query(
+		"SELECT "+importJobColumns+" FROM import_jobs WHERE user_id = $1 ORDER BY created_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.ImportJob{}
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// storeImportedSamples bulk-inserts samples, one statement per metric,
+// skipping samples the user already has at the same time and repeats within
+// the batch. It returns how many samples were inserted.
+func storeImportedSamples(tx *sql.Tx, userID int, importJobID *int, samples []importedSample) (int, error) {
+	byMetric := make(map[string][]importedSample)
+	for _, sample := range samples {
+		byMetric[sample.Metric] = append(byMetric[sample.Metric], sample)
+	}
+
+	created := 0
+
+	for metric, batch := range byMetric {
+		target := importedSampleTables[metric]
+
+		timestamps := make([]string, len(batch))
+		values := make([]int64, len(batch))
+		deviceIDs := make([]sql.NullInt64, len(batch))
+		importedDeviceIDs := make([]sql.NullInt64, len(batch))
+		activityTypes := make([]sql.NullString, len(batch))
+		distances := make([]sql.NullFloat64, len(batch))
+		sources := make([]string, len(batch))
+
+		for i, sample := range batch {
+			timestamps[i] = importTimestamp(sample.Timestamp)
+			values[i] = int64(math.Round(sample.Value))
+			if sample.DeviceID != nil {
+				deviceIDs[i] = sql.NullInt64{Int64: int64(*sample.DeviceID), Valid: true}
+			}
+			if sample.ImportedDeviceID != nil {
+				importedDeviceIDs[i] = sql.NullInt64{Int64: int64(*sample.ImportedDeviceID), Valid: true}
+			}
+			if sample.ActivityType != nil {
+				activityTypes[i] = sql.NullString{String: *sample.ActivityType, Valid: true}
+			}
+			if sample.Distance != nil {
+				distances[i] = sql.NullFloat64{Float64: *sample.Distance, Valid: true}
+			}
+			sources[i] = sample.Source
+		}
+
+		// Estimated calories never block a reported value, and are replaced by it
+		existing := ""
+		if metric == models.ShareMetricCalories {
+			existing = " AND NOT t.is_estimated"
+
+			if err := clearEstimatedCaloriesWindows(tx, userID, batch); err != nil {
+				return created, err
+			}
+		}
+
+		// Steps carry a distance; the other metrics an activity type
+		extras, extraType := pq.Array(activityTypes), "text[]"
+		if metric == models.ShareMetricSteps {
+			extras, extraType = pq.Array(distances), "float8[]"
+		}
+
+		result, err := tx.Exec(
+			`INSERT INTO `+target.table+` (user_id, import_job_id, timestamp, `+target.column+`, device_id, imported_device_id, `+target.extra+`, source)
+			 SELECT DISTINCT ON (s.ts) $1, $2, s.ts, s.value, s.device_id, s.imported_device_id, s.extra, s.source
+			 FROM unnest($3::timestamp[], $4::integer[], $5::integer[], $6::integer[], $7::`+extraType+`, $8::text[])
+				AS s(ts, value, device_id, imported_device_id, extra, source)
+			 WHERE NOT EXISTS (
+				SELECT 1 FROM `+target.table+` t WHERE t.user_id = $1 AND t.timestamp = s.ts`+existing+`
+			 )
+			 ORDER BY s.ts`,
+			userID, importJobID, pq.Array(timestamps), pq.Array(values), pq.Array(deviceIDs), pq.Array(importedDeviceIDs),
+			extras, pq.Array(sources),
+		)
+		if err != nil {
+			return created, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return created, err
+		}
+		created += int(affected)
+	}
+
+	return created, nil
+}
+
+// clearEstimatedCaloriesWindows removes estimates for every window that
+// contains one of the imported calories samples
+func clearEstimatedCaloriesWindows(tx *sql.Tx, userID int, samples []importedSample) error {
+	seen := make(map[time.Time]bool)
+	var windows []string
+
+	for _, sample := range samples {
+		window := sample.Timestamp.Truncate(estimationWindow)
+		if !seen[window] {
+			seen[window] = true
+			windows = append(windows, importTimestamp(window))
+		}
+	}
+
+	_, err := tx.Exec(
+		`DELETE FROM calories_data c
+		 WHERE c.user_id = $1 AND c.is_estimated
+		   AND EXISTS (
+			SELECT 1 FROM unnest($2::timestamp[]) AS w(start)
+			WHERE c.timestamp >= w.start AND c.timestamp < w.start + $3 * INTERVAL '1 second'
+		   )`,
+		userID, pq.Array(windows), int(estimationWindow.Seconds()),
+	)
+	return err
+}
+
+// importTimestamp formats a time for a timestamp array parameter. Samples
+// are stored in server local time like those recorded by devices.
+func importTimestamp(t time.Time) string {
+	return t.Local().Format(importTimestampLayout)
+}
+
+// storedTime returns a value scanned from a TIMESTAMP column, which holds
+// server local wall-clock time, as that time in the server's location
+func storedTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+)
+
+// ErrUserNotFound is returned when an operation targets a user that doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
+// LoginThrottledError is returned when too many failed logins were made for
+// an account or from an IP address
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "too many failed login attempts; the account is temporarily locked"
+	}
+	return "too many failed login attempts; please wait before trying again"
+}
+
+// loginAttempts is the failed login state of one account or IP address
+type loginAttempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// LoginAttemptStore keeps failed login counters. Failures older than the
+// window are forgotten when the next one is recorded.
+type LoginAttemptStore interface {
+	Get(key string) (loginAttempts, error)
+	RecordFailure(key string, now time.Time, window time.Duration) (loginAttempts, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// throttlePolicy holds the thresholds for one kind of throttle key
+type throttlePolicy struct {
+	scope       string
+	maxFailures int
+}
+
+// loginThrottleSettings returns the backoff and lockout settings
+func loginThrottleSettings() (window, backoffBase, backoffMax, lockout time.Duration) {
+	return config.GetEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		config.GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		config.GetEnvDuration("LOGIN_BACKOFF_MAX", 30*time.Second),
+		config.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+func accountThrottlePolicy() throttlePolicy {
+	return throttlePolicy{scope: "account", maxFailures: config.GetEnvInt("LOGIN_MAX_ACCOUNT_FAILURES", 5)}
+}
+
+func ipThrottlePolicy() throttlePolicy {
+	return throttlePolicy{scope: "ip", maxFailures: config.GetEnvInt("LOGIN_MAX_IP_FAILURES", 20)}
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+var (
+	loginAttemptStore     LoginAttemptStore
+	loginAttemptStoreOnce sync.Once
+)
+
+// GetLoginAttemptStore returns the store selected by LOGIN_THROTTLE_STORE.
+// The in-memory store is per instance; use "postgres" when running several.
+func GetLoginAttemptStore() LoginAttemptStore {
+	loginAttemptStoreOnce.Do(func() {
+		switch config.GetEnv("LOGIN_THROTTLE_STORE", "memory") {
+		case "postgres":
+			loginAttemptStore = postgresAttemptStore{}
+		default:
+			loginAttemptStore = newMemoryAttemptStore()
+		}
+	})
+	return loginAttemptStore
+}
+
+// backoffDelay doubles the wait after each consecutive failure, up to max
+func backoffDelay(failures int, base, max time.Duration) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// checkLoginThrottle rejects a login attempt while the key is locked out or
+// still backing off from its last failure
+func checkLoginThrottle(key string) error {
+	state, err := GetLoginAttemptStore().Get(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	window, backoffBase, backoffMax, _ := loginThrottleSettings()
+
+	if now.Before(state.LockedUntil) {
+		return &LoginThrottledError{RetryAfter: state.LockedUntil.Sub(now), Locked: true}
+	}
+
+	if state.Failures == 0 || now.Sub(state.LastFailureAt) > window {
+		return nil
+	}
+
+	retryAt := state.LastFailureAt.Add(backoffDelay(state.Failures, backoffBase, backoffMax))
+	if now.Before(retryAt) {
+		return &LoginThrottledError{RetryAfter: retryAt.Sub(now)}
+	}
+
+	return nil
+}
+
+// checkLoginAllowed checks both the account and the IP address throttles
+func checkLoginAllowed(email, ip string) error {
+	if err := checkLoginThrottle(accountThrottleKey(email)); err != nil {
+		return err
+	}
+	if ip != "" {
+		return checkLoginThrottle(ipThrottleKey(ip))
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the account and the IP
+// address, locking either one out once its threshold is reached
+func recordLoginFailure(email, ip string, userID int) {
+	recordThrottleFailure(accountThrottleKey(email), accountThrottlePolicy(), email, ip, userID)
+	if ip != "" {
+		recordThrottleFailure(ipThrottleKey(ip), ipThrottlePolicy(), "", ip, 0)
+	}
+}
+
+func recordThrottleFailure(key string, policy throttlePolicy, email, ip string, userID int) {
+	store := GetLoginAttemptStore()
+	window, _, _, lockout := loginThrottleSettings()
+	now := time.Now()
+
+	state, err := store.RecordFailure(key, now, window)
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", key, err)
+		return
+	}
+
+	if state.Failures < policy.maxFailures || now.Before(state.LockedUntil) {
+		return
+	}
+
+	lockedUntil := now.Add(lockout)
+	if err := store.Lock(key, lockedUntil); err != nil {
+		log.Printf("Failed to lock %s: %v", key, err)
+		return
+	}
+
+	log.Printf("Locked %s after %d failed login attempts until %s", key, state.Failures, lockedUntil.Format(time.RFC3339))
+	recordLockout(policy.scope, userID, email, ip, state.Failures, lockedUntil)
+}
+
+// recordLockout writes an audit record of a lockout
+func recordLockout(scope string, userID int, email, ip string, failures int, lockedUntil time.Time) {
+	_, err := config.DB.Exec(
+		`INSERT INTO account_lockouts (user_id, scope, email, ip_address, failures, locked_until)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+		scope,
+		sql.NullString{String: email, Valid: email != ""},
+		sql.NullString{String: ip, Valid: ip != ""},
+		failures,
+		lockedUntil,
+	)
+	if err != nil {
+		log.Printf("Failed to record lockout of %s %s: %v", scope, email+ip, err)
+	}
+}
+
+// resetLoginFailures clears the account's failure count after a successful login
+func resetLoginFailures(email string) {
+	if err := GetLoginAttemptStore().Reset(accountThrottleKey(email)); err != nil {
+		log.Printf("Failed to reset login failures for %s: %v", email, err)
+	}
+}
+
+// UnlockAccount lifts a lockout of a user's account before it expires
+func UnlockAccount(userID int, unlockedBy string) error {
+	var email string
+	err := config.DB.QueryRow("SELECT email FROM users WHERE user_id = $1", userID).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if err := GetLoginAttemptStore().Reset(accountThrottleKey(email)); err != nil {
+		return err
+	}
+
+	_, err = config.DB.Exec(
+		`UPDATE account_lockouts SET unlocked_at = $1, unlocked_by = $2
+		 WHERE user_id = $3 AND unlocked_at IS NULL AND locked_until > $1`,
+		time.Now(), unlockedBy, userID,
+	)
+	return err
+}
+
+// memoryAttemptStore keeps login failures in process memory
+type memoryAttemptStore struct {
+	mu      sync.Mutex
+	entries map[string]loginAttempts
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{entries: make(map[string]loginAttempts)}
+}
+
+func (s *memoryAttemptStore) Get(key string) (loginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries[key], nil
+}
+
+func (s *memoryAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (loginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.entries[key]
+	if now.Sub(state.LastFailureAt) > window {
+		state.Failures = 0
+	}
+	state.Failures++
+	state.LastFailureAt = now
+	s.entries[key] = state
+
+	// Drop stale entries so the map doesn't grow without bound
+	for k, entry := range s.entries {
+		if now.Sub(entry.LastFailureAt) > window && now.After(entry.LockedUntil) {
+			delete(s.entries, k)
+		}
+	}
+
+	return state, nil
+}
+
+func (s *memoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.entries[key]
+	state.LockedUntil = until
+	s.entries[key] = state
+	return nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// postgresAttemptStore keeps login failures in the login_attempts table so
+// every instance sees the same counters
+type postgresAttemptStore struct{}
+
+func (postgresAttemptStore) Get(key string) (loginAttempts, error) {
+	var state loginAttempts
+	var lockedUntil sql.NullTime
+
+	err := config.DB.QueryRow(
+		"SELECT failures, last_failure_at, locked_until FROM login_attempts WHERE throttle_key = $1",
+		key,
+	).Scan(&state.Failures, &state.LastFailureAt, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return loginAttempts{}, nil
+		}
+		return loginAttempts{}, err
+	}
+
+	state.LockedUntil = lockedUntil.Time
+	return state, nil
+}
+
+func (postgresAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (loginAttempts, error) {
+	var state loginAttempts
+	var lockedUntil sql.NullTime
+
+	err := config.DB.QueryRow(
+		`INSERT INTO login_attempts (throttle_key, failures, last_failure_at)
+		 VALUES ($1, 1, $2)
+		 ON CONFLICT (throttle_key) DO UPDATE SET
+		     failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+		     last_failure_at = $2
+		 RETURNING failures, last_failure_at, locked_until`,
+		key, now, now.Add(-window),
+	).Scan(&state.Failures, &state.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return loginAttempts{}, err
+	}
+
+	state.LockedUntil = lockedUntil.Time
+	return state, nil
+}
+
+func (postgresAttemptStore) Lock(key string, until time.Time) error {
+	_, err := config.DB.Exec("UPDATE login_attempts SET locked_until = $1 WHERE throttle_key = $2", until, key)
+	return err
+}
+
+func (postgresAttemptStore) Reset(key string) error {
+	_, err := config.DB.Exec("DELETE FROM login_attempts WHERE throttle_key = $1", key)
+	return err
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+)
+
+// EmailMessage is a plain-text email
+type EmailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(msg EmailMessage) error
+}
+
+// LogMailer writes emails to the application log. Intended for local development.
+type LogMailer struct{}
+
+// Send logs the email instead of delivering it
+func (LogMailer) Send(msg EmailMessage) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each email to a file in Dir. Intended for local development.
+type FileMailer struct {
+	Dir string
+}
+
+// Send writes the email to a new .eml file
+func (m FileMailer) Send(msg EmailMessage) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFileName(msg.To))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", msg.To, msg.Subject, msg.Body)
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o600)
+}
+
+// SMTPMailer delivers emails through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the email over SMTP
+func (m SMTPMailer) Send(msg EmailMessage) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	content := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.From, msg.To, msg.Subject, msg.Body,
+	)
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, []byte(content))
+}
+
+var (
+	mailerOnce    sync.Once
+	defaultMailer Mailer
+)
+
+// GetMailer returns the mailer selected by the MAILER environment variable
+// ("log", "file" or "smtp"), defaulting to the log mailer
+func GetMailer() Mailer {
+	mailerOnce.Do(func() {
+		switch config.GetEnv("MAILER", "log") {
+		case "file":
+			defaultMailer = FileMailer{Dir: config.GetEnv("MAILER_DIR", "mail")}
+		case "smtp":
+			defaultMailer = SMTPMailer{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     config.GetEnv("SMTP_PORT", "587"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     config.GetEnv("MAIL_FROM", "no-reply@notifyvital.app"),
+			}
+		default:
+			defaultMailer = LogMailer{}
+		}
+	})
+	return defaultMailer
+}
+
+// appURL builds a link to a page of the client application
+func appURL(path string) string {
+	return strings.TrimRight(config.GetEnv("APP_BASE_URL", "http://localhost:3000"), "/") + path
+}
+
+// sanitizeFileName keeps only characters that are safe in file names
+func sanitizeFileName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, value)
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const (
+	mfaChallengePurpose = "mfa_challenge"
+	recoveryCodeCount   = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication has not been set up")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+	ErrInvalidMFAToken   = errors.New("invalid or expired MFA challenge")
+)
+
+// EnrollMFA generates a new TOTP secret for the user. It stays inactive
+// until confirmed with a code from the authenticator app.
+func EnrollMFA(userID int) (*models.MFAEnrollment, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = config.DB.Exec(
+		`INSERT INTO user_mfa (user_id, secret, enabled, last_used_step, created_at)
+		 VALUES ($1, $2, false, 0, $3)
+		 ON CONFLICT (user_id) DO UPDATE SET secret = $2, enabled = false, last_used_step = 0, created_at = $3`,
+		userID, secret, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totpURI(secret, user.Email),
+	}, nil
+}
+
+// ConfirmMFA enables two-factor authentication once the user proves their
+// authenticator works, and returns a fresh set of recovery codes
+func ConfirmMFA(userID int, code string) ([]string, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabled bool
+
+	err = tx.QueryRow(
+		"SELECT secret, enabled FROM user_mfa WHERE user_id = $1 FOR UPDATE",
+		userID,
+	).Scan(&secret, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, err
+	}
+
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec(
+		"UPDATE user_mfa SET enabled = true, confirmed_at = $1, last_used_step = $2 WHERE user_id = $3",
+		time.Now(), step, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableMFA turns off two-factor authentication after checking a current
+// TOTP or recovery code
+func DisableMFA(userID int, code string) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = verifySecondFactor(tx, userID, code, "")
+	if err == ErrInvalidMFACode {
+		// The code may also be a recovery code
+		err = verifySecondFactor(tx, userID, "", code)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a current TOTP code
+func RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := verifySecondFactor(tx, userID, code, ""); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// createMFAChallenge returns the short-lived token that lets a user who
+// passed the password check complete login with their second factor. The
+// challenge is recorded so its attempts can be limited and it can be used
+// only once.
+func createMFAChallenge(userID int) (string, time.Time, error) {
+	ttl := config.GetEnvDuration("MFA_CHALLENGE_EXPIRY", 5*time.Minute)
+	expiresAt := time.Now().Add(ttl)
+
+	jti, err := randomToken(24)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Expired challenges are no longer needed
+	_, err = config.DB.Exec("DELETE FROM mfa_challenges WHERE user_id = $1 AND expires_at < $2", userID, time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	_, err = config.DB.Exec(
+		"INSERT INTO mfa_challenges (jti, user_id, expires_at) VALUES ($1, $2, $3)",
+		jti, userID, expiresAt,
+	)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, err := signPurposeToken(mfaChallengePurpose, jwt.MapClaims{"user_id": userID, "jti": jti}, ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// useMFAChallenge counts an attempt against a challenge. It fails once the
+// challenge has expired, been consumed or run out of attempts.
+func useMFAChallenge(jti string, userID int) error {
+	result, err := config.DB.Exec(
+		`UPDATE mfa_challenges SET attempts = attempts + 1
+		 WHERE jti = $1 AND user_id = $2 AND consumed_at IS NULL AND expires_at > $3 AND attempts < $4`,
+		jti, userID, time.Now(), config.GetEnvInt("MFA_CHALLENGE_ATTEMPTS", 5),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrInvalidMFAToken
+	}
+
+	return nil
+}
+
+// CompleteMFALogin finishes a two-step login with a TOTP or recovery code
+// and starts a session
+func CompleteMFALogin(req models.MFALoginRequest, meta models.SessionMetadata) (*models.User, *models.SessionTokens, error) {
+	claims, err := parsePurposeToken(req.MFAToken, mfaChallengePurpose)
+	if err != nil {
+		return nil, nil, ErrInvalidMFAToken
+	}
+
+	userIDFloat, ok := claims["user_id"].(float64)
+	jti, hasJTI := claims["jti"].(string)
+	if !ok || !hasJTI {
+		return nil, nil, ErrInvalidMFAToken
+	}
+	userID := int(userIDFloat)
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Second-factor guesses count towards the same brute-force limits as passwords
+	if err := checkLoginAllowed(user.Email, meta.IPAddress); err != nil {
+		return nil, nil, err
+	}
+
+	// Each attempt is counted before the code is checked
+	if err := useMFAChallenge(jti, userID); err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := verifySecondFactor(tx, userID, req.Code, req.RecoveryCode); err != nil {
+		if err == ErrInvalidMFACode {
+			recordLoginFailure(user.Email, meta.IPAddress, userID)
+		}
+		return nil, nil, err
+	}
+
+	// A successful challenge can't be replayed
+	_, err = tx.Exec("UPDATE mfa_challenges SET consumed_at = $1 WHERE jti = $2", time.Now(), jti)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	resetLoginFailures(user.Email)
+
+	if !user.IsActive {
+		return nil, nil, ErrAccountInactive
+	}
+
+	if meta.DeviceName == "" {
+		meta.DeviceName = req.DeviceName
+	}
+
+	tokens, err := StartSession(userID, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = config.DB.Exec("UPDATE users SET last_login = $1 WHERE user_id = $2", time.Now(), userID)
+	if err != nil {
+		// Non-critical error, just log it
+		// log.Printf("Failed to update last login: %v", err)
+	}
+
+	return user, tokens, nil
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code for an
+// enabled user. TOTP steps and recovery codes can each be used only once.
+func verifySecondFactor(tx *sql.Tx, userID int, code, recoveryCode string) error {
+	var secret string
+	var enabled bool
+	var lastUsedStep int64
+
+	err := tx.QueryRow(
+		"SELECT secret, enabled, last_used_step FROM user_mfa WHERE user_id = $1 FOR UPDATE",
+		userID,
+	).Scan(&secret, &enabled, &lastUsedStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrMFANotEnrolled
+		}
+		return err
+	}
+
+	if !enabled {
+		return ErrMFANotEnrolled
+	}
+
+	if recoveryCode != "" {
+		result, err := tx.Exec(
+			"UPDATE mfa_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+			time.Now(), userID, hashToken(normalizeRecoveryCode(recoveryCode)),
+		)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok || step <= lastUsedStep {
+		return ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec("UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2", step, userID)
+	return err
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and stores a new
+// set. Only hashes are stored; the plaintext codes are shown once.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code like "K7QF-2MXD-9TRA"
+func generateRecoveryCode() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, v := range buf {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(alphabet[int(v)%len(alphabet)])
+	}
+
+	return b.String(), nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
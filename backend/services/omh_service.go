@@ -0,0 +1,307 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// omhSourceName is reported as the source of data points served by the API
+const omhSourceName = "Notify Vital"
+
+// omhSchemaVersions are the schema versions written for each schema
+var omhSchemaVersions = map[string]string{
+	models.OMHSchemaHeartRate:        "2.0",
+	models.OMHSchemaStepCount:        "2.0",
+	models.OMHSchemaCaloriesBurned:   "2.0",
+	models.OMHSchemaPhysicalActivity: "1.2",
+}
+
+var ErrInvalidOMHDataPoint = errors.New("invalid Open mHealth data point")
+
+// omhHeader builds the header of a data point served by the API
+func omhHeader(schema, id string, userID int, createdAt time.Time, modality string) models.OMHHeader {
+	return models.OMHHeader{
+		ID:               id,
+		CreationDateTime: storedTime(createdAt).UTC(),
+		SchemaID: models.OMHSchemaID{
+			Namespace: "omh", Name: schema, Version: omhSchemaVersions[schema],
+		},
+		AcquisitionProvenance: &models.OMHAcquisitionProvenance{SourceName: omhSourceName, Modality: modality},
+		UserID:                strconv.Itoa(userID),
+	}
+}
+
+// omhInstant returns a time frame for a single point in time, given as
+// stored in server local time
+func omhInstant(t time.Time) models.OMHTimeFrame {
+	t = storedTime(t).UTC()
+	return models.OMHTimeFrame{DateTime: &t}
+}
+
+// OMHHeartRateDataPoint maps a heart rate sample to a heart-rate data point
+func OMHHeartRateDataPoint(d models.HeartRateData) models.OMHDataPoint {
+	return models.OMHDataPoint{
+		Header: omhHeader(models.OMHSchemaHeartRate, fmt.Sprintf("hr-%d", d.ID), d.UserID, d.CreatedAt, "sensed"),
+		Body: models.OMHHeartRate{
+			HeartRate:          models.OMHUnitValue{Value: float64(d.HeartRate), Unit: "beats/min"},
+			EffectiveTimeFrame: omhInstant(d.Timestamp),
+		},
+	}
+}
+
+// OMHStepCountDataPoint maps a steps sample to a step-count data point
+func OMHStepCountDataPoint(d models.StepsData) models.OMHDataPoint {
+	return models.OMHDataPoint{
+		Header: omhHeader(models.OMHSchemaStepCount, fmt.Sprintf("steps-%d", d.ID), d.UserID, d.CreatedAt, "sensed"),
+		Body: models.OMHStepCount{
+			StepCount:          models.OMHUnitValue{Value: float64(d.StepsCount), Unit: "steps"},
+			EffectiveTimeFrame: omhInstant(d.Timestamp),
+		},
+	}
+}
+
+// OMHCaloriesBurnedDataPoint maps a calories sample to a calories-burned
+// data point. Estimated samples carry no modality, as they were neither
+// sensed nor self-reported.
+func OMHCaloriesBurnedDataPoint(d models.CaloriesData) models.OMHDataPoint {
+	modality := "sensed"
+	if d.IsEstimated {
+		modality = ""
+	}
+
+	body := models.OMHCaloriesBurned{
+		KcalBurned:         models.OMHUnitValue{Value: float64(d.CaloriesBurned), Unit: "kcal"},
+		EffectiveTimeFrame: omhInstant(d.Timestamp),
+	}
+	if d.ActivityType != nil {
+		body.ActivityName = *d.ActivityType
+	}
+
+	return models.OMHDataPoint{
+		Header: omhHeader(models.OMHSchemaCaloriesBurned, fmt.Sprintf("cal-%d", d.ID), d.UserID, d.CreatedAt, modality),
+		Body:   body,
+	}
+}
+
+// OMHPhysicalActivityDataPoint maps a workout to a physical-activity data
+// point covering the workout's time span
+func OMHPhysicalActivityDataPoint(w models.Workout) models.OMHDataPoint {
+	start := storedTime(w.StartTime).UTC()
+	interval := &models.OMHTimeInterval{StartDateTime: &start}
+	if w.EndTime != nil {
+		end := storedTime(*w.EndTime).UTC()
+		interval.EndDateTime = &end
+	}
+
+	modality := "self-reported"
+	if w.Detected {
+		modality = "sensed"
+	}
+
+	return models.OMHDataPoint{
+		Header: omhHeader(models.OMHSchemaPhysicalActivity, fmt.Sprintf("workout-%d", w.WorkoutID), w.UserID, w.CreatedAt, modality),
+		Body: models.OMHPhysicalActivity{
+			ActivityName:       w.WorkoutType,
+			EffectiveTimeFrame: models.OMHTimeFrame{TimeInterval: interval},
+			Distance:           &models.OMHUnitValue{Value: w.Distance, Unit: "m"},
+			KcalBurned:         &models.OMHUnitValue{Value: float64(w.CaloriesBurned), Unit: "kcal"},
+		},
+	}
+}
+
+// ImportOMHDataPoints stores heart-rate, step-count, calories-burned and
+// physical-activity data points, given as a JSON array or a single data
+// point, as the user's samples. Valid data points are stored even when
+// others fail; samples already recorded at the same time are skipped.
+func ImportOMHDataPoints(userID int, body []byte) (*models.OMHImportResult, error) {
+	var incoming []struct {
+		Header models.OMHHeader `json:"header"`
+		Body   json.RawMessage  `json:"body"`
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		trimmed = append(append([]byte{'['}, trimmed...), ']')
+	}
+	if err := json.Unmarshal(trimmed, &incoming); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOMHDataPoint, err)
+	}
+
+	result := &models.OMHImportResult{Errors: []models.OMHImportError{}}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, point := range incoming {
+		sample, err := omhSample(point.Header.SchemaID, point.Body)
+		if err != nil {
+			result.Errors = append(result.Errors, models.OMHImportError{Index: i, Error: err.Error()})
+			continue
+		}
+
+		_, created, err := storeImportedSample(tx, userID, *sample)
+		if err != nil {
+			return nil, err
+		}
+
+		if created {
+			result.Created++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// omhSample decodes a data point body according to its schema and converts
+// it to a sample. A physical activity is stored as the calories it burned.
+func omhSample(schema models.OMHSchemaID, body json.RawMessage) (*importedSample, error) {
+	if schema.Namespace != "" && schema.Namespace != "omh" {
+		return nil, fmt.Errorf("%w: unsupported schema namespace %q", ErrInvalidOMHDataPoint, schema.Namespace)
+	}
+
+	sample := &importedSample{Source: models.SourceOMH}
+	var frame models.OMHTimeFrame
+
+	switch schema.Name {
+	case models.OMHSchemaHeartRate:
+		var heartRate models.OMHHeartRate
+		if err := json.Unmarshal(body, &heartRate); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOMHDataPoint, err)
+		}
+		sample.Metric = models.ShareMetricHeartRate
+		sample.Value = heartRate.HeartRate.Value
+		frame = heartRate.EffectiveTimeFrame
+
+	case models.OMHSchemaStepCount:
+		var stepCount models.OMHStepCount
+		if err := json.Unmarshal(body, &stepCount); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOMHDataPoint, err)
+		}
+		sample.Metric = models.ShareMetricSteps
+		sample.Value = stepCount.StepCount.Value
+		frame = stepCount.EffectiveTimeFrame
+
+	case models.OMHSchemaCaloriesBurned:
+		var calories models.OMHCaloriesBurned
+		if err := json.Unmarshal(body, &calories); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOMHDataPoint, err)
+		}
+		kcal, err := omhKcal(calories.KcalBurned)
+		if err != nil {
+			return nil, err
+		}
+		sample.Metric = models.ShareMetricCalories
+		sample.Value = kcal
+		sample.ActivityType = omhActivityName(calories.ActivityName)
+		frame = calories.EffectiveTimeFrame
+
+	case models.OMHSchemaPhysicalActivity:
+		var activity models.OMHPhysicalActivity
+		if err := json.Unmarshal(body, &activity); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOMHDataPoint, err)
+		}
+		if activity.KcalBurned == nil {
+			return nil, fmt.Errorf("%w: physical-activity needs kcal_burned to be stored", ErrInvalidOMHDataPoint)
+		}
+		kcal, err := omhKcal(*activity.KcalBurned)
+		if err != nil {
+			return nil, err
+		}
+		sample.Metric = models.ShareMetricCalories
+		sample.Value = kcal
+		sample.ActivityType = omhActivityName(activity.ActivityName)
+		frame = activity.EffectiveTimeFrame
+
+	default:
+		return nil, fmt.Errorf("%w: unsupported schema %q", ErrInvalidOMHDataPoint, schema.Name)
+	}
+
+	if sample.Value < 0 {
+		return nil, fmt.Errorf("%w: value must not be negative", ErrInvalidOMHDataPoint)
+	}
+
+	timestamp, err := omhTimestamp(frame)
+	if err != nil {
+		return nil, err
+	}
+	sample.Timestamp = timestamp
+
+	return sample, nil
+}
+
+// omhTimestamp returns the time a sample is recorded at: the point in time,
+// or the end of an interval, matching how devices report samples
+func omhTimestamp(frame models.OMHTimeFrame) (time.Time, error) {
+	if frame.DateTime != nil {
+		return *frame.DateTime, nil
+	}
+
+	if interval := frame.TimeInterval; interval != nil {
+		if interval.EndDateTime != nil {
+			return *interval.EndDateTime, nil
+		}
+		if interval.StartDateTime != nil && interval.Duration != nil {
+			duration, err := omhDuration(*interval.Duration)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return interval.StartDateTime.Add(duration), nil
+		}
+		if interval.StartDateTime != nil {
+			return *interval.StartDateTime, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: effective_time_frame is required", ErrInvalidOMHDataPoint)
+}
+
+// omhDuration converts an OMH duration unit value
+func omhDuration(value models.OMHUnitValue) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ns": time.Nanosecond, "us": time.Microsecond, "ms": time.Millisecond,
+		"sec": time.Second, "min": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "wk": 7 * 24 * time.Hour,
+	}
+
+	unit, ok := units[value.Unit]
+	if !ok {
+		return 0, fmt.Errorf("%w: unsupported duration unit %q", ErrInvalidOMHDataPoint, value.Unit)
+	}
+	return time.Duration(value.Value * float64(unit)), nil
+}
+
+// omhKcal returns an energy value in kilocalories
+func omhKcal(value models.OMHUnitValue) (float64, error) {
+	switch strings.ToLower(value.Unit) {
+	case "", "kcal":
+		return value.Value, nil
+	case "kj":
+		return value.Value / 4.184, nil
+	default:
+		return 0, fmt.Errorf("%w: unsupported energy unit %q", ErrInvalidOMHDataPoint, value.Unit)
+	}
+}
+
+// omhActivityName returns an activity name as an optional activity type
+func omhActivityName(name string) *string {
+	if name == "" {
+		return nil
+	}
+	return &name
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrAlreadyOrgMember     = errors.New("user is already a member of this organization")
+	ErrOrgMemberNotFound    = errors.New("member not found")
+	ErrLastOrgAdmin         = errors.New("an organization must keep at least one admin")
+	ErrNotClinician         = errors.New("only clinician or admin accounts can join an organization's staff")
+	ErrAlreadyEnrolled      = errors.New("patient is already enrolled or invited")
+	ErrEnrollmentNotFound   = errors.New("enrollment not found")
+)
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// nullableInt converts a nullable integer column to a pointer
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	intValue := int(value.Int64)
+	return &intValue
+}
+
+// CreateOrganization creates an organization with the creator as its admin
+func CreateOrganization(userID int, req models.CreateOrganizationRequest) (*models.Organization, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	org := models.Organization{Name: strings.TrimSpace(req.Name), CreatedBy: &userID, Role: models.OrgRoleAdmin}
+
+	err = tx.QueryRow(
+		"INSERT INTO organizations (name, created_by) VALUES ($1, $2) RETURNING id, created_at",
+		org.Name, userID,
+	).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)",
+		org.ID, userID, models.OrgRoleAdmin,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// GetOrganizations lists the organizations a user is a member of
+func GetOrganizations(userID int) ([]models.Organization, error) {
+	rows, err := config.DB.Query(
+		`SELECT o.id, o.name, o.created_by, o.created_at, m.role
+		 FROM organizations o JOIN organization_members m ON m.org_id = o.id
+		 WHERE m.user_id = $1 ORDER BY o.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []models.Organization
+
+	for rows.Next() {
+		var org models.Organization
+		var createdBy sql.NullInt64
+		if err := rows.Scan(&org.ID, &org.Name, &createdBy, &org.CreatedAt, &org.Role); err != nil {
+			return nil, err
+		}
+		org.CreatedBy = nullableInt(createdBy)
+		orgs = append(orgs, org)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// GetOrganization retrieves an organization along with the user's role in it
+func GetOrganization(orgID, userID int) (*models.Organization, error) {
+	var org models.Organization
+	var createdBy sql.NullInt64
+
+	err := config.DB.QueryRow(
+		`SELECT o.id, o.name, o.created_by, o.created_at, COALESCE(m.role, '')
+		 FROM organizations o LEFT JOIN organization_members m ON m.org_id = o.id AND m.user_id = $2
+		 WHERE o.id = $1`,
+		orgID, userID,
+	).Scan(&org.ID, &org.Name, &createdBy, &org.CreatedAt, &org.Role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+
+	org.CreatedBy = nullableInt(createdBy)
+
+	return &org, nil
+}
+
+// GetOrgRole returns the user's role in an organization, or "" if they
+// aren't a member
+func GetOrgRole(orgID, userID int) (string, error) {
+	var role string
+
+	err := config.DB.QueryRow(
+		"SELECT role FROM organization_members WHERE org_id = $1 AND user_id = $2",
+		orgID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return role, err
+}
+
+// GetOrgMembers lists an organization's staff
+func GetOrgMembers(orgID int) ([]models.OrgMember, error) {
+	rows, err := config.DB.Query(
+		`SELECT u.user_id, u.username, u.email, m.role, m.joined_at
+		 FROM organization_members m JOIN users u ON u.user_id = m.user_id
+		 WHERE m.org_id = $1 ORDER BY u.username`,
+		orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.OrgMember
+
+	for rows.Next() {
+		var member models.OrgMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Email, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddOrgMember adds an existing clinician or admin account to an organization's staff
+func AddOrgMember(orgID int, req models.AddOrgMemberRequest) (*models.OrgMember, error) {
+	var member models.OrgMember
+	var globalRole string
+
+	err := config.DB.QueryRow(
+		"SELECT user_id, username, email, role FROM users WHERE LOWER(email) = LOWER($1) AND is_active",
+		req.Email,
+	).Scan(&member.UserID, &member.Username, &member.Email, &globalRole)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if globalRole != models.RoleClinician && globalRole != models.RoleAdmin {
+		return nil, ErrNotClinician
+	}
+
+	member.Role = req.Role
+
+	err = config.DB.QueryRow(
+		"INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3) RETURNING joined_at",
+		orgID, member.UserID, member.Role,
+	).Scan(&member.JoinedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyOrgMember
+		}
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// RemoveOrgMember removes a member from an organization's staff
+func RemoveOrgMember(orgID, userID int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the staff list so two admins can't remove each other concurrently
+	var adminCount int
+	var role sql.NullString
+
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FILTER (WHERE role = $3), MAX(role) FILTER (WHERE user_id = $2)
+		 FROM (SELECT role, user_id FROM organization_members WHERE org_id = $1 FOR UPDATE) members`,
+		orgID, userID, models.OrgRoleAdmin,
+	).Scan(&adminCount, &role)
+	if err != nil {
+		return err
+	}
+
+	if !role.Valid {
+		return ErrOrgMemberNotFound
+	}
+
+	if role.String == models.OrgRoleAdmin && adminCount <= 1 {
+		return ErrLastOrgAdmin
+	}
+
+	if _, err := tx.Exec("DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2", orgID, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// enrollmentColumns lists the columns read by scanEnrollment
+const enrollmentColumns = `e.id, e.org_id, o.name, e.patient_id, u.username, e.invited_by, e.status,
+	e.consented_at, e.revoked_at, e.created_at`
+
+// enrollmentJoins joins the organization and patient names onto enrollments
+const enrollmentJoins = ` FROM patient_enrollments e
+	JOIN organizations o ON o.id = e.org_id
+	JOIN users u ON u.user_id = e.patient_id`
+
+// scanEnrollment scans a row selected with enrollmentColumns
+func scanEnrollment(row rowScanner) (*models.PatientEnrollment, error) {
+	var enrollment models.PatientEnrollment
+	var invitedBy sql.NullInt64
+	var consentedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&enrollment.ID, &enrollment.OrgID, &enrollment.OrgName, &enrollment.PatientID, &enrollment.PatientUsername,
+		&invitedBy, &enrollment.Status, &consentedAt, &revokedAt, &enrollment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment.InvitedBy = nullableInt(invitedBy)
+
+	if consentedAt.Valid {
+		consentedAtValue := consentedAt.Time
+		enrollment.ConsentedAt = &consentedAtValue
+	}
+
+	if revokedAt.Valid {
+		revokedAtValue := revokedAt.Time
+		enrollment.RevokedAt = &revokedAtValue
+	}
+
+	return &enrollment, nil
+}
+
+// getEnrollment retrieves an enrollment by ID
+func getEnrollment(enrollmentID int) (*models.PatientEnrollment, error) {
+	enrollment, err := scanEnrollment(config.DB.QueryRow(
+		"SELECT "+enrollmentColumns+enrollmentJoins+" WHERE e.id = $1",
+		enrollmentID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEnrollmentNotFound
+		}
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// EnrollPatient invites a patient to an organization. The patient's data
+// becomes visible to the organization's staff only after they consent.
+func EnrollPatient(orgID, invitedBy int, req models.EnrollPatientRequest) (*models.PatientEnrollment, error) {
+	var patientID int
+	var patientEmail string
+
+	err := config.DB.QueryRow(
+		"SELECT user_id, email FROM users WHERE LOWER(email) = LOWER($1) AND is_active",
+		req.Email,
+	).Scan(&patientID, &patientEmail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	var enrollmentID int
+	err = config.DB.QueryRow(
+		"INSERT INTO patient_enrollments (org_id, patient_id, invited_by) VALUES ($1, $2, $3) RETURNING id",
+		orgID, patientID, invitedBy,
+	).Scan(&enrollmentID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyEnrolled
+		}
+		return nil, err
+	}
+
+	enrollment, err := getEnrollment(enrollmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = GetMailer().Send(EmailMessage{
+		To:      patientEmail,
+		Subject: fmt.Sprintf("%s invited you to share your health data", enrollment.OrgName),
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n%s would like to monitor your health data. Nothing is shared until you consent:\n%s",
+			enrollment.PatientUsername, enrollment.OrgName, appURL("/enrollments"),
+		),
+	})
+	if err != nil {
+		log.Printf("Failed to send enrollment invitation %d: %v", enrollment.ID, err)
+	}
+
+	return enrollment, nil
+}
+
+// RemovePatient ends a patient's enrollment in an organization
+func RemovePatient(orgID, patientID int) error {
+	result, err := config.DB.Exec(
+		`UPDATE patient_enrollments SET status = $1, revoked_at = $2
+		 WHERE org_id = $3 AND patient_id = $4 AND status <> $1`,
+		models.EnrollmentStatusRevoked, time.Now(), orgID, patientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrEnrollmentNotFound
+	}
+
+	return nil
+}
+
+// GetPatientEnrollments lists a patient's open enrollments and invitations
+func GetPatientEnrollments(patientID int) ([]models.PatientEnrollment, error) {
+	rows, err := config.DB.Query(
+		"SELECT "+enrollmentColumns+enrollmentJoins+" WHERE e.patient_id = $1 AND e.status <> $2 ORDER BY e.created_at DESC",
+		patientID, models.EnrollmentStatusRevoked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var enrollments []models.PatientEnrollment
+
+	for rows.Next() {
+		enrollment, err := scanEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, *enrollment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return enrollments, nil
+}
+
+// ConsentToEnrollment accepts a pending enrollment invitation
+func ConsentToEnrollment(patientID, enrollmentID int) (*models.PatientEnrollment, error) {
+	result, err := config.DB.Exec(
+		"UPDATE patient_enrollments SET status = $1, consented_at = $2 WHERE id = $3 AND patient_id = $4 AND status = $5",
+		models.EnrollmentStatusActive, time.Now(), enrollmentID, patientID, models.EnrollmentStatusPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if affected == 0 {
+		return nil, ErrEnrollmentNotFound
+	}
+
+	return getEnrollment(enrollmentID)
+}
+
+// WithdrawEnrollment lets a patient decline an invitation or withdraw consent
+func WithdrawEnrollment(patientID, enrollmentID int) error {
+	result, err := config.DB.Exec(
+		"UPDATE patient_enrollments SET status = $1, revoked_at = $2 WHERE id = $3 AND patient_id = $4 AND status <> $1",
+		models.EnrollmentStatusRevoked, time.Now(), enrollmentID, patientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrEnrollmentNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// RequestPasswordReset emails a single-use reset link to the account with
+// the given email. Unknown or inactive emails are ignored so the endpoint
+// doesn't reveal which addresses are registered.
+func RequestPasswordReset(email string) error {
+	var userID int
+	var isActive bool
+
+	err := config.DB.QueryRow(
+		"SELECT user_id, is_active FROM users WHERE email = $1",
+		email,
+	).Scan(&userID, &isActive)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if !isActive {
+		return nil
+	}
+
+	// The link is created and sent in the background so the response takes
+	// as long as for an unknown email
+	go sendPasswordReset(userID, email)
+
+	return nil
+}
+
+// sendPasswordReset stores a new reset token for a user and emails the link.
+// Failures are logged, since the caller has already been answered.
+func sendPasswordReset(userID int, email string) {
+	token, err := randomToken(32)
+	if err != nil {
+		log.Printf("Failed to create password reset token for user %d: %v", userID, err)
+		return
+	}
+
+	expiry := config.GetEnvDuration("PASSWORD_RESET_EXPIRY", time.Hour)
+
+	_, err = config.DB.Exec(
+		"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		userID, hashToken(token), time.Now().Add(expiry),
+	)
+	if err != nil {
+		log.Printf("Failed to store password reset token for user %d: %v", userID, err)
+		return
+	}
+
+	link := appURL("/reset-password?token=" + url.QueryEscape(token))
+
+	err = GetMailer().Send(EmailMessage{
+		To:      email,
+		Subject: "Reset your Notify Vital password",
+		Body: fmt.Sprintf(
+			"We received a request to reset your password.\n\nOpen this link to choose a new one:\n%s\n\nThe link expires in %s. If you didn't ask for this, you can ignore this email.",
+			link, expiry,
+		),
+	})
+	if err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", userID, err)
+	}
+}
+
+// ResetPassword sets a new password using a reset token. The token and any
+// other outstanding tokens of the user are consumed, and all of the user's
+// sessions are revoked.
+func ResetPassword(token, newPassword string) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tokenID int
+	var userID int
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+
+	err = tx.QueryRow(
+		"SELECT id, user_id, expires_at, used_at FROM password_reset_tokens WHERE token_hash = $1 FOR UPDATE",
+		hashToken(token),
+	).Scan(&tokenID, &userID, &expiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if _, err := tx.Exec("UPDATE users SET password_hash = $1 WHERE user_id = $2", hashedPassword, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL",
+		now, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE sessions SET is_valid = false WHERE user_id = $1 AND is_valid", userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	sessionCache.invalidateUser(userID)
+	return nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+	"github.com/lib/pq"
+)
+
+// ErrAlertNotFound is returned when an open alert does not exist for a
+// patient of the organization
+var ErrAlertNotFound = errors.New("alert not found")
+
+// alertConditions are the heart rate conditions that raise each alert kind
+var alertConditions = map[string]string{
+	models.AlertKindHighHeartRate: fmt.Sprintf("hr.heart_rate >= %d", panelHighHeartRate),
+	models.AlertKindLowHeartRate:  fmt.Sprintf("hr.heart_rate < %d", panelLowHeartRate),
+}
+
+// queuedAlertChecks marks users with an alert check waiting to run
+var queuedAlertChecks sync.Map
+
+// CheckPatientAlertsAsync checks a user's new samples for alerts in the
+// background, unless a check for the user is already queued
+func CheckPatientAlertsAsync(userID int) {
+	if _, queued := queuedAlertChecks.LoadOrStore(userID, struct{}{}); queued {
+		return
+	}
+
+	go func() {
+		// Samples arriving from here on need a new check to be seen
+		queuedAlertChecks.Delete(userID)
+
+		if err := checkPatientAlerts(userID); err != nil {
+			log.Printf("Alert check failed for user %d: %v", userID, err)
+		}
+	}()
+}
+
+// checkPatientAlerts opens an alert of each kind for an actively enrolled
+// patient whose newest breaching heart rate sample is more recent than the
+// last alert of that kind was raised or acknowledged. A kind that is already
+// open is left alone.
+func checkPatientAlerts(userID int) error {
+	since := time.Now().Add(-config.GetEnvDuration("ALERT_LOOKBACK", 24*time.Hour))
+
+	for kind, condition := range alertConditions {
+		_, err := config.DB.Exec(`
+			INSERT INTO patient_alerts (user_id, kind, value, triggered_at)
+			SELECT $1, $2, hr.heart_rate, hr.timestamp
+			FROM heart_rate_data hr
+			WHERE hr.user_id = $1 AND hr.timestamp >= $3 AND `+condition+`
+			  AND EXISTS (
+			      SELECT 1 FROM patient_enrollments
+			      WHERE patient_id = $1 AND status = $4
+			  )
+			  AND hr.timestamp > COALESCE((
+			      SELECT MAX(GREATEST(triggered_at, acknowledged_at))
+			      FROM patient_alerts
+			      WHERE user_id = $1 AND kind = $2
+			  ), '-infinity')
+			ORDER BY hr.timestamp DESC
+			LIMIT 1
+			ON CONFLICT (user_id, kind) WHERE acknowledged_at IS NULL DO NOTHING`,
+			userID, kind, since, models.EnrollmentStatusActive,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getOpenPatientAlerts returns the open alerts of the given patients, newest
+// first, keyed by patient ID
+func getOpenPatientAlerts(patientIDs []int) (map[int][]models.PatientAlert, error) {
+	alerts := make(map[int][]models.PatientAlert)
+	if len(patientIDs) == 0 {
+		return alerts, nil
+	}
+
+	rows, err := config.DB.Query(
+		`SELECT id, user_id, kind, value, triggered_at, acknowledged_at, acknowledged_by, created_at
+		FROM patient_alerts
+		WHERE user_id = ANY($1) AND acknowledged_at IS NULL
+		ORDER BY triggered_at DESC`,
+		pq.Array(patientIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		alert, err := scanPatientAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts[alert.PatientID] = append(alerts[alert.PatientID], *alert)
+	}
+
+	return alerts, rows.Err()
+}
+
+// AcknowledgePatientAlert closes an open alert of a patient actively
+// enrolled in the organization, recording the member who acknowledged it
+func AcknowledgePatientAlert(orgID, patientID, alertID, memberID int) (*models.PatientAlert, error) {
+	row := config.DB.QueryRow(
+		`UPDATE patient_alerts SET acknowledged_at = $1, acknowledged_by = $2
+		WHERE id = $3 AND user_id = $4 AND acknowledged_at IS NULL
+		  AND EXISTS (
+		      SELECT 1 FROM patient_enrollments
+		      WHERE org_id = $5 AND patient_id = $4 AND status = $6
+		  )
+		RETURNING id, user_id, kind, value, triggered_at, acknowledged_at, acknowledged_by, created_at`,
+		time.Now(), memberID, alertID, patientID, orgID, models.EnrollmentStatusActive,
+	)
+
+	alert, err := scanPatientAlert(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return alert, nil
+}
+
+// scanPatientAlert scans a patient_alerts row
+func scanPatientAlert(row rowScanner) (*models.PatientAlert, error) {
+	var alert models.PatientAlert
+	var acknowledgedAt sql.NullTime
+	var acknowledgedBy sql.NullInt32
+
+	err := row.Scan(
+		&alert.ID, &alert.PatientID, &alert.Kind, &alert.Value, &alert.TriggeredAt,
+		&acknowledgedAt, &acknowledgedBy, &alert.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	alert.TriggeredAt = storedTime(alert.TriggeredAt)
+	alert.CreatedAt = storedTime(alert.CreatedAt)
+	if acknowledgedAt.Valid {
+		acknowledged := storedTime(acknowledgedAt.Time)
+		alert.AcknowledgedAt = &acknowledged
+	}
+	if acknowledgedBy.Valid {
+		memberID := int(acknowledgedBy.Int32)
+		alert.AcknowledgedBy = &memberID
+	}
+
+	return &alert, nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const (
+	// Heart rates outside these bounds raise a patient's risk score
+	panelHighHeartRate     = 120
+	panelElevatedHeartRate = 100
+	panelLowHeartRate      = 50
+
+	// Patients not seen for these long raise their risk score
+	panelStaleAfter     = 6 * time.Hour
+	panelVeryStaleAfter = 24 * time.Hour
+)
+
+// GetPatientPanel lists an organization's consenting, active patients with
+// their latest health data, latest heart rate and when they were last seen,
+// sorted by "risk" (default), "last_seen" or "name". Each patient's open
+// alerts are included and count toward the risk score.
+func GetPatientPanel(orgID int, sortBy string) ([]models.PatientPanelEntry, error) {
+	query := `
+		SELECT u.user_id, u.username, u.email, e.consented_at,
+		       h.data_id, h.device_id, h.timestamp, h.heart_rate, h.steps, h.calories_burned,
+		       h.activity_status, h.activity_gauge_value, h.created_at,
+		       hr.heart_rate, hr.timestamp,
+		       GREATEST(
+		           h.timestamp,
+		           hr.timestamp,
+		           (SELECT MAX(timestamp) FROM steps_data WHERE user_id = u.user_id)
+		       )
+		FROM patient_enrollments e
+		JOIN users u ON u.user_id = e.patient_id
+		LEFT JOIN LATERAL (
+			SELECT data_id, device_id, timestamp, heart_rate, steps, calories_burned,
+			       activity_status, activity_gauge_value, created_at
+			FROM health_data
+			WHERE user_id = u.user_id
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) h ON true
+		LEFT JOIN LATERAL (
+			SELECT heart_rate, timestamp
+			FROM heart_rate_data
+			WHERE user_id = u.user_id
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) hr ON true
+		WHERE e.org_id = $1 AND e.status = $2 AND u.is_active
+	`
+
+	rows, err := config.DB.Query(query, orgID, models.EnrollmentStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var panel []models.PatientPanelEntry
+
+	for rows.Next() {
+		var entry models.PatientPanelEntry
+		var consentedAt sql.NullTime
+		var dataID, deviceID, heartRate, steps, caloriesBurned sql.NullInt32
+		var sampleHeartRate sql.NullInt32
+		var timestamp, createdAt, sampleTimestamp, lastSeen sql.NullTime
+		var activityStatus sql.NullString
+		var activityGaugeValue sql.NullFloat64
+
+		err := rows.Scan(
+			&entry.PatientID, &entry.Username, &entry.Email, &consentedAt,
+			&dataID, &deviceID, &timestamp, &heartRate, &steps, &caloriesBurned,
+			&activityStatus, &activityGaugeValue, &createdAt,
+			&sampleHeartRate, &sampleTimestamp, &lastSeen,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.EnrolledAt = consentedAt.Time
+
+		if dataID.Valid {
+			latest := models.HealthData{
+				DataID:             int(dataID.Int32),
+				UserID:             entry.PatientID,
+				Timestamp:          timestamp.Time,
+				ActivityStatus:     activityStatus.String,
+				ActivityGaugeValue: activityGaugeValue.Float64,
+				CreatedAt:          createdAt.Time,
+			}
+
+			if deviceID.Valid {
+				deviceIDInt := int(deviceID.Int32)
+				latest.DeviceID = &deviceIDInt
+			}
+
+			if heartRate.Valid {
+				heartRateInt := int(heartRate.Int32)
+				latest.HeartRate = &heartRateInt
+			}
+
+			if steps.Valid {
+				stepsInt := int(steps.Int32)
+				latest.Steps = &stepsInt
+			}
+
+			if caloriesBurned.Valid {
+				caloriesBurnedInt := int(caloriesBurned.Int32)
+				latest.CaloriesBurned = &caloriesBurnedInt
+			}
+
+			entry.Latest = &latest
+		}
+
+		// The heart rate sample is used when it is newer than the health data
+		// record or that record has none
+		switch {
+		case sampleHeartRate.Valid && (entry.Latest == nil || entry.Latest.HeartRate == nil ||
+			sampleTimestamp.Time.After(entry.Latest.Timestamp)):
+			heartRateInt := int(sampleHeartRate.Int32)
+			entry.HeartRate = &heartRateInt
+		case entry.Latest != nil:
+			entry.HeartRate = entry.Latest.HeartRate
+		}
+
+		if lastSeen.Valid {
+			lastSeenValue := lastSeen.Time
+			entry.LastSeen = &lastSeenValue
+		}
+
+		panel = append(panel, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	patientIDs := make([]int, len(panel))
+	for i, entry := range panel {
+		patientIDs[i] = entry.PatientID
+	}
+
+	openAlerts, err := getOpenPatientAlerts(patientIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range panel {
+		panel[i].OpenAlerts = openAlerts[panel[i].PatientID]
+		if panel[i].OpenAlerts == nil {
+			panel[i].OpenAlerts = []models.PatientAlert{}
+		}
+		panel[i].RiskScore, panel[i].RiskFactors = assessPatientRisk(panel[i], now)
+	}
+
+	sortPatientPanel(panel, sortBy)
+
+	return panel, nil
+}
+
+// assessPatientRisk scores how urgently a patient needs attention from their
+// latest heart rate, open alerts and how long ago their device last reported, returning
+// the factors that raised the score
+func assessPatientRisk(entry models.PatientPanelEntry, now time.Time) (int, []string) {
+	score := 0
+	factors := []string{}
+
+	if entry.HeartRate != nil {
+		switch heartRate := *entry.HeartRate; {
+		case heartRate >= panelHighHeartRate:
+			score += 3
+			factors = append(factors, "high_heart_rate")
+		case heartRate >= panelElevatedHeartRate:
+			score++
+			factors = append(factors, "elevated_heart_rate")
+		case heartRate < panelLowHeartRate:
+			score += 2
+			factors = append(factors, "low_heart_rate")
+		}
+	}
+
+	// Each open alert needs a clinician's attention until acknowledged
+	if len(entry.OpenAlerts) > 0 {
+		score += 2 * len(entry.OpenAlerts)
+		factors = append(factors, "open_alerts")
+	}
+
+	switch {
+	case entry.LastSeen == nil:
+		score += 2
+		factors = append(factors, "no_data")
+	case now.Sub(*entry.LastSeen) > panelVeryStaleAfter:
+		score += 2
+		factors = append(factors, "not_seen_24h")
+	case now.Sub(*entry.LastSeen) > panelStaleAfter:
+		score++
+		factors = append(factors, "not_seen_6h")
+	}
+
+	return score, factors
+}
+
+// sortPatientPanel orders the panel in place
+func sortPatientPanel(panel []models.PatientPanelEntry, sortBy string) {
+	lastSeen := func(entry models.PatientPanelEntry) time.Time {
+		if entry.LastSeen == nil {
+			return time.Time{}
+		}
+		return *entry.LastSeen
+	}
+
+	sort.SliceStable(panel, func(i, j int) bool {
+		switch sortBy {
+		case "name":
+			return panel[i].Username < panel[j].Username
+		case "last_seen":
+			// Least recently seen first
+			return lastSeen(panel[i]).Before(lastSeen(panel[j]))
+		default:
+			if panel[i].RiskScore != panel[j].RiskScore {
+				return panel[i].RiskScore > panel[j].RiskScore
+			}
+			return lastSeen(panel[i]).Before(lastSeen(panel[j]))
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// UpdateUserProfile updates the profile fields that are present in the request
+func UpdateUserProfile(userID int, req models.UpdateProfileRequest) (*models.User, error) {
+	query := `
+		UPDATE users SET
+			date_of_birth = COALESCE($1::date, date_of_birth),
+			weight_kg = COALESCE($2, weight_kg),
+			sex = COALESCE($3, sex),
+			height_cm = COALESCE($4, height_cm),
+			walking_stride_cm = COALESCE($5, walking_stride_cm),
+			running_stride_cm = COALESCE($6, running_stride_cm)
+		WHERE user_id = $7
+	`
+
+	_, err := config.DB.Exec(
+		query,
+		req.DateOfBirth,
+		req.WeightKg,
+		req.Sex,
+		req.HeightCm,
+		req.WalkingStrideCm,
+		req.RunningStrideCm,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetUserByID(userID)
+}
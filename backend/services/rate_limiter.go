@@ -0,0 +1,234 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+)
+
+// RateLimitPolicy allows Limit requests per Window, refilled continuously.
+// Up to Limit requests may be made in a burst.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// defaultRateLimits are used when RATE_LIMIT_<NAME> is not set
+var defaultRateLimits = map[string]string{
+	"global":    "1000/1m",
+	"auth":      "20/1m",
+	"ingestion": "120/1m",
+	"read":      "300/1m",
+}
+
+// RateLimitPolicyFor returns the policy for a route group from
+// RATE_LIMIT_<NAME>, formatted as "<limit>/<window>" such as "120/1m".
+// A limit of 0 disables rate limiting for the group.
+func RateLimitPolicyFor(name string) RateLimitPolicy {
+	key := "RATE_LIMIT_" + strings.ToUpper(name)
+	value := config.GetEnv(key, defaultRateLimits[name])
+
+	policy, ok := parseRateLimit(name, value)
+	if !ok {
+		log.Printf("Warning: invalid %s %q, using default %s", key, value, defaultRateLimits[name])
+		policy, _ = parseRateLimit(name, defaultRateLimits[name])
+	}
+	return policy
+}
+
+func parseRateLimit(name, value string) (RateLimitPolicy, bool) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimitPolicy{Name: name}, false
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit < 0 {
+		return RateLimitPolicy{Name: name}, false
+	}
+
+	window, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || window <= 0 {
+		return RateLimitPolicy{Name: name}, false
+	}
+
+	return RateLimitPolicy{Name: name, Limit: limit, Window: window}, true
+}
+
+// RateLimitResult describes the state of a bucket after a request
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, if denied
+}
+
+// RateLimitStore keeps token buckets
+type RateLimitStore interface {
+	Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+var (
+	rateLimitStore     RateLimitStore
+	rateLimitStoreOnce sync.Once
+)
+
+// GetRateLimitStore returns the store selected by RATE_LIMIT_STORE. The
+// in-memory store is per instance; use "postgres" to share limits.
+func GetRateLimitStore() RateLimitStore {
+	rateLimitStoreOnce.Do(func() {
+		switch config.GetEnv("RATE_LIMIT_STORE", "memory") {
+		case "postgres":
+			rateLimitStore = &postgresRateLimitStore{}
+		default:
+			rateLimitStore = newMemoryRateLimitStore()
+		}
+	})
+	return rateLimitStore
+}
+
+// takeToken refills a bucket for the time elapsed since it was last updated
+// and takes one token from it if available
+func takeToken(tokens float64, updatedAt, now time.Time, policy RateLimitPolicy) (float64, RateLimitResult) {
+	limit := float64(policy.Limit)
+	perSecond := limit / policy.Window.Seconds()
+
+	if elapsed := now.Sub(updatedAt).Seconds(); elapsed > 0 {
+		tokens = math.Min(limit, tokens+elapsed*perSecond)
+	}
+
+	var result RateLimitResult
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	}
+
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = time.Duration((limit - tokens) / perSecond * float64(time.Second))
+
+	return tokens, result
+}
+
+// memoryRateLimitStore keeps token buckets in process memory
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *memoryRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = memoryBucket{tokens: float64(policy.Limit), updatedAt: now}
+	}
+
+	tokens, result := takeToken(bucket.tokens, bucket.updatedAt, now, policy)
+	s.buckets[key] = memoryBucket{tokens: tokens, updatedAt: now, fullAt: now.Add(result.Reset)}
+
+	// Buckets that have refilled are equivalent to missing ones; drop them
+	// once a minute so the map doesn't grow without bound
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if now.After(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	return result, nil
+}
+
+// postgresRateLimitStore keeps token buckets in the rate_limit_buckets table
+type postgresRateLimitStore struct {
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (s *postgresRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.sweep(now)
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO rate_limit_buckets (bucket_key, tokens, updated_at)
+		 VALUES ($1, $2, $3) ON CONFLICT (bucket_key) DO NOTHING`,
+		key, float64(policy.Limit), now,
+	)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	var tokens float64
+	var updatedAt time.Time
+
+	err = tx.QueryRow(
+		"SELECT tokens, updated_at FROM rate_limit_buckets WHERE bucket_key = $1 FOR UPDATE",
+		key,
+	).Scan(&tokens, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return RateLimitResult{Allowed: true, Remaining: policy.Limit}, nil
+		}
+		return RateLimitResult{}, err
+	}
+
+	tokens, result := takeToken(tokens, updatedAt, now, policy)
+
+	_, err = tx.Exec(
+		"UPDATE rate_limit_buckets SET tokens = $1, updated_at = $2, full_at = $3 WHERE bucket_key = $4",
+		tokens, now, now.Add(result.Reset), key,
+	)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return result, nil
+}
+
+// sweep deletes buckets that have refilled, which are equivalent to missing
+// ones, at most once a minute per instance so the table doesn't grow without
+// bound
+func (s *postgresRateLimitStore) sweep(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) <= time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	if _, err := config.DB.Exec("DELETE FROM rate_limit_buckets WHERE full_at < $1", now); err != nil {
+		log.Printf("Failed to sweep rate limit buckets: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+// importedSample is a heart rate, steps or calories reading parsed from an
+// external format, before it is stored in its metric's table. Metric is one
+// of the share metric names.
+type importedSample struct {
+	Metric    string
+	Timestamp time.Time
+	Value     float64
+	DeviceID  *int
+
+	// ImportedDeviceID is the imported_devices row of the device that
+	// recorded an activity file
+	ImportedDeviceID *int
+
+	// ActivityType labels heart rate and calories samples; steps have none
+	ActivityType *string
+
+	// Distance is the distance in meters covered by a steps sample
+	Distance *float64
+
+	// Source is the import kind or format the sample came from
+	Source string
+}
+
+// importedSampleTables maps each importable metric to its table, value
+// column and the column holding the sample's activity type or distance
+var importedSampleTables = map[string]struct{ table, column, extra string }{
+	models.ShareMetricHeartRate: {"heart_rate_data", "heart_rate", "activity_type"},
+	models.ShareMetricSteps:     {"steps_data", "steps_count", "distance"},
+	models.ShareMetricCalories:  {"calories_data", "calories_burned", "activity_type"},
+}
+
+// storeImportedSample inserts a sample unless the user already has a
+// reported sample of the metric at the same timestamp, so imports can be
+// repeated safely. It returns the sample's row ID and whether it was created.
+func storeImportedSample(tx *sql.Tx, userID int, sample importedSample) (int, bool, error) {
+	target := importedSampleTables[sample.Metric]
+	value := int(math.Round(sample.Value))
+
+	// Estimated calories never block a reported value
+	existingQuery := "SELECT id FROM " + target.table + " WHERE user_id = $1 AND timestamp = $2"
+	if sample.Metric == models.ShareMetricCalories {
+		existingQuery += " AND NOT is_estimated"
+	}
+
+	// Samples are stored in server local time like those recorded by devices
+	timestamp := sample.Timestamp.Local()
+
+	var id int
+	err := tx.QueryRow(existingQuery+" LIMIT 1", userID, timestamp).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	// Reported calories replace any estimate for the same window
+	if sample.Metric == models.ShareMetricCalories {
+		window := timestamp.Truncate(estimationWindow)
+		_, err := tx.Exec(
+			"DELETE FROM calories_data WHERE user_id = $1 AND is_estimated AND timestamp >= $2 AND timestamp < $3",
+			userID, window, window.Add(estimationWindow),
+		)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	var extra interface{} = sample.ActivityType
+	if sample.Metric == models.ShareMetricSteps {
+		extra = sample.Distance
+	}
+
+	err = tx.QueryRow(
+		"INSERT INTO "+target.table+" (user_id, device_id, imported_device_id, timestamp, "+target.column+", "+target.extra+", source) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		userID, sample.DeviceID, sample.ImportedDeviceID, timestamp, value, extra, sample.Source,
+	).Scan(&id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, true, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+)
+
+// sessionCacheEntry is the cached validity of one session
+type sessionCacheEntry struct {
+	sessionID int
+	userID    int
+	role      string
+	valid     bool
+	expiresAt time.Time
+}
+
+// sessionValidityCache is a small TTL cache of session validity keyed by jti.
+// Revocations made by this instance invalidate it immediately; revocations
+// made elsewhere take effect once the TTL passes.
+type sessionValidityCache struct {
+	mu      sync.Mutex
+	entries map[string]sessionCacheEntry
+}
+
+var sessionCache = &sessionValidityCache{entries: make(map[string]sessionCacheEntry)}
+
+// sessionCacheTTL returns how long a session lookup is trusted
+func sessionCacheTTL() time.Duration {
+	return config.GetEnvDuration("SESSION_CACHE_TTL", 30*time.Second)
+}
+
+func (c *sessionValidityCache) get(jti string) (sessionCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[jti]
+	if !ok {
+		return sessionCacheEntry{}, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, jti)
+		return sessionCacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+func (c *sessionValidityCache) set(jti string, entry sessionCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry.expiresAt = now.Add(sessionCacheTTL())
+	c.entries[jti] = entry
+
+	// Drop expired entries so the cache doesn't grow without bound
+	for key, existing := range c.entries {
+		if now.After(existing.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidateUser forgets every cached session of a user
+func (c *sessionValidityCache) invalidateUser(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.userID == userID {
+			delete(c.entries, key)
+		}
+	}
+}
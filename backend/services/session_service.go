@@ -0,0 +1,322 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used; all sessions of this login were revoked")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrAccountInactive     = errors.New("account is not active")
+)
+
+// randomToken returns a URL-safe random token with n bytes of entropy
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex SHA-256 of an opaque token. Tokens are random
+// with high entropy, so a fast hash is enough to keep them useless if leaked.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// refreshTokenExpiry returns how long refresh tokens stay valid
+func refreshTokenExpiry() time.Duration {
+	return config.GetEnvDuration("REFRESH_TOKEN_EXPIRY", 30*24*time.Hour)
+}
+
+// createSession issues an access token and a refresh token and stores them
+// as a new generation of the given token family
+func createSession(tx *sql.Tx, userID int, familyID string, meta models.SessionMetadata) (*models.SessionTokens, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, accessExpiresAt, err := GenerateJWT(userID, jti)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	refreshExpiresAt := now.Add(refreshTokenExpiry())
+
+	_, err = tx.Exec(
+		`INSERT INTO sessions (
+			user_id, token, ip_address, issued_at, expires_at, is_valid,
+			refresh_token_hash, refresh_expires_at, family_id, jti, user_agent, device_name
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		userID, accessToken, meta.IPAddress, now, accessExpiresAt, true,
+		hashToken(refreshToken), refreshExpiresAt, familyID, jti, meta.UserAgent, meta.DeviceName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SessionTokens{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// StartSession opens a new token family for a user, as done on login
+func StartSession(userID int, meta models.SessionMetadata) (*models.SessionTokens, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tokens, err := createSession(tx, userID, familyID, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access and refresh token
+// pair. Each refresh token can be used once; presenting a token that was
+// already rotated revokes every session in its family.
+func RefreshSession(refreshToken string, meta models.SessionMetadata) (*models.User, *models.SessionTokens, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var sessionID int
+	var userID int
+	var familyID sql.NullString
+	var isValid bool
+	var rotatedAt sql.NullTime
+	var refreshExpiresAt sql.NullTime
+	var deviceName sql.NullString
+
+	err = tx.QueryRow(
+		`SELECT session_id, user_id, family_id, is_valid, rotated_at, refresh_expires_at, device_name
+		 FROM sessions WHERE refresh_token_hash = $1 FOR UPDATE`,
+		hashToken(refreshToken),
+	).Scan(&sessionID, &userID, &familyID, &isValid, &rotatedAt, &refreshExpiresAt, &deviceName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		return nil, nil, err
+	}
+
+	// A rotated token being presented again means it was stolen or replayed
+	if rotatedAt.Valid {
+		_, err := tx.Exec("UPDATE sessions SET is_valid = false WHERE family_id = $1", familyID.String)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+		sessionCache.invalidateUser(userID)
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	if !isValid || !refreshExpiresAt.Valid || time.Now().After(refreshExpiresAt.Time) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !user.IsActive {
+		return nil, nil, ErrAccountInactive
+	}
+
+	_, err = tx.Exec(
+		"UPDATE sessions SET is_valid = false, rotated_at = $1 WHERE session_id = $2",
+		time.Now(), sessionID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the device name given at login unless the client sends a new one
+	if meta.DeviceName == "" {
+		meta.DeviceName = deviceName.String
+	}
+
+	tokens, err := createSession(tx, userID, familyID.String, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	// The rotated generation's access token is no longer valid
+	sessionCache.invalidateUser(userID)
+
+	return user, tokens, nil
+}
+
+// ValidateSession reports whether the session identified by a token's jti is
+// still valid and belongs to an active account, and returns the session ID
+// and the user's role. Results are cached briefly to avoid a query per request.
+func ValidateSession(claims *models.TokenClaims) (int, string, error) {
+	if entry, ok := sessionCache.get(claims.JTI); ok {
+		if !entry.valid || entry.userID != claims.UserID {
+			return 0, "", ErrSessionRevoked
+		}
+		return entry.sessionID, entry.role, nil
+	}
+
+	var sessionID int
+	var userID int
+	var isValid bool
+	var role string
+
+	err := config.DB.QueryRow(
+		`SELECT s.session_id, s.user_id, s.is_valid AND u.is_active, u.role
+		 FROM sessions s JOIN users u ON u.user_id = s.user_id
+		 WHERE s.jti = $1`,
+		claims.JTI,
+	).Scan(&sessionID, &userID, &isValid, &role)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	valid := err == nil && isValid && userID == claims.UserID
+	sessionCache.set(claims.JTI, sessionCacheEntry{sessionID: sessionID, userID: userID, role: role, valid: valid})
+
+	if !valid {
+		return 0, "", ErrSessionRevoked
+	}
+	return sessionID, role, nil
+}
+
+// GetActiveSessions lists a user's active logins, one per token family,
+// showing the latest generation of each
+func GetActiveSessions(userID int, currentSessionID int) ([]models.Session, error) {
+	query := `
+		SELECT DISTINCT ON (COALESCE(family_id, session_id::text))
+			session_id, user_id, ip_address, user_agent, device_name, issued_at, expires_at, refresh_expires_at, is_valid,
+			family_id = (SELECT family_id FROM sessions WHERE session_id = $2) OR session_id = $2
+		FROM sessions
+		WHERE user_id = $1 AND is_valid
+		  AND COALESCE(refresh_expires_at, expires_at) > $3
+		ORDER BY COALESCE(family_id, session_id::text), issued_at DESC
+	`
+
+	rows, err := config.DB.Query(query, userID, currentSessionID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+
+	for rows.Next() {
+		var session models.Session
+		var ipAddress sql.NullString
+		var userAgent sql.NullString
+		var deviceName sql.NullString
+		var refreshExpiresAt sql.NullTime
+		var current sql.NullBool
+
+		err := rows.Scan(
+			&session.SessionID, &session.UserID, &ipAddress, &userAgent, &deviceName, &session.IssuedAt,
+			&session.ExpiresAt, &refreshExpiresAt, &session.IsValid, &current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		session.IPAddress = ipAddress.String
+		session.UserAgent = userAgent.String
+		session.DeviceName = deviceName.String
+		session.Current = current.Valid && current.Bool
+
+		if refreshExpiresAt.Valid {
+			refreshExpiresAtValue := refreshExpiresAt.Time
+			session.RefreshExpiresAt = &refreshExpiresAtValue
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user along with every other
+// generation in its token family
+func RevokeSession(userID, sessionID int) error {
+	result, err := config.DB.Exec(
+		`UPDATE sessions SET is_valid = false
+		 WHERE user_id = $1
+		   AND (session_id = $2 OR family_id = (SELECT family_id FROM sessions WHERE session_id = $2 AND user_id = $1))`,
+		userID, sessionID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+
+	sessionCache.invalidateUser(userID)
+	return nil
+}
+
+// RevokeAllSessions logs a user out everywhere
+func RevokeAllSessions(userID int) error {
+	_, err := config.DB.Exec("UPDATE sessions SET is_valid = false WHERE user_id = $1 AND is_valid", userID)
+	if err != nil {
+		return err
+	}
+
+	sessionCache.invalidateUser(userID)
+	return nil
+}
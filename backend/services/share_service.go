@@ -0,0 +1,287 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+var (
+	ErrShareGrantNotFound  = errors.New("share grant not found")
+	ErrCannotShareWithSelf = errors.New("you cannot share your data with yourself")
+	ErrInvalidShareExpiry  = errors.New("expires_at must be in the future")
+)
+
+// shareGrantColumns lists the columns read by scanShareGrant
+const shareGrantColumns = `g.id, g.owner_id, u.username, g.grantee_email, g.grantee_id, g.metrics,
+	g.expires_at, g.accepted_at, g.revoked_at, g.created_at`
+
+// scanShareGrant scans a row selected with shareGrantColumns
+func scanShareGrant(row rowScanner) (*models.ShareGrant, error) {
+	var grant models.ShareGrant
+	var granteeID sql.NullInt64
+	var expiresAt, acceptedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&grant.ID, &grant.OwnerID, &grant.OwnerUsername, &grant.GranteeEmail, &granteeID, pq.Array(&grant.Metrics),
+		&expiresAt, &acceptedAt, &revokedAt, &grant.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if granteeID.Valid {
+		granteeIDValue := int(granteeID.Int64)
+		grant.GranteeID = &granteeIDValue
+	}
+
+	if expiresAt.Valid {
+		expiresAtValue := expiresAt.Time
+		grant.ExpiresAt = &expiresAtValue
+	}
+
+	if acceptedAt.Valid {
+		acceptedAtValue := acceptedAt.Time
+		grant.AcceptedAt = &acceptedAtValue
+	}
+
+	if revokedAt.Valid {
+		revokedAtValue := revokedAt.Time
+		grant.RevokedAt = &revokedAtValue
+	}
+
+	switch {
+	case grant.RevokedAt != nil:
+		grant.Status = models.ShareStatusRevoked
+	case grant.ExpiresAt != nil && time.Now().After(*grant.ExpiresAt):
+		grant.Status = models.ShareStatusExpired
+	case grant.AcceptedAt != nil:
+		grant.Status = models.ShareStatusActive
+	default:
+		grant.Status = models.ShareStatusPending
+	}
+
+	return &grant, nil
+}
+
+// queryShareGrants runs a query selecting shareGrantColumns
+func queryShareGrants(query string, args ...interface{}) ([]models.ShareGrant, error) {
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []models.ShareGrant
+
+	for rows.Next() {
+		grant, err := scanShareGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, *grant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// CreateShareGrant invites another account, by email, to read the chosen
+// metrics of the owner's data. The grant takes effect once accepted.
+func CreateShareGrant(ownerID int, req models.CreateShareGrantRequest) (*models.ShareGrant, error) {
+	owner, err := GetUserByID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == strings.ToLower(owner.Email) {
+		return nil, ErrCannotShareWithSelf
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidShareExpiry
+	}
+
+	var grantID int
+	err = config.DB.QueryRow(
+		`INSERT INTO share_grants (owner_id, grantee_email, metrics, expires_at)
+		 VALUES ($1, $2, $3, $4) RETURNING id`,
+		ownerID, email, pq.Array(dedupeStrings(req.Metrics)), req.ExpiresAt,
+	).Scan(&grantID)
+	if err != nil {
+		return nil, err
+	}
+
+	grant, err := getShareGrant(grantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The invitation can still be seen in the app if the email isn't delivered
+	if err := sendShareInvitation(owner, grant); err != nil {
+		log.Printf("Failed to send share invitation %d: %v", grant.ID, err)
+	}
+
+	return grant, nil
+}
+
+// sendShareInvitation tells the invited person about a new grant
+func sendShareInvitation(owner *models.User, grant *models.ShareGrant) error {
+	return GetMailer().Send(EmailMessage{
+		To:      grant.GranteeEmail,
+		Subject: fmt.Sprintf("%s shared their health data with you on Notify Vital", owner.Username),
+		Body: fmt.Sprintf(
+			"Hi,\n\n%s invited you to view their %s.\n\nSign in or create an account with this email address to accept:\n%s",
+			owner.Username, strings.Join(grant.Metrics, ", "), appURL("/shared-with-me"),
+		),
+	})
+}
+
+// getShareGrant retrieves a grant by ID
+func getShareGrant(grantID int) (*models.ShareGrant, error) {
+	grant, err := scanShareGrant(config.DB.QueryRow(
+		"SELECT "+shareGrantColumns+" FROM share_grants g JOIN users u ON u.user_id = g.owner_id WHERE g.id = $1",
+		grantID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrShareGrantNotFound
+		}
+		return nil, err
+	}
+	return grant, nil
+}
+
+// GetShareGrants lists the grants a user has given
+func GetShareGrants(ownerID int) ([]models.ShareGrant, error) {
+	return queryShareGrants(
+		"SELECT "+shareGrantColumns+` FROM share_grants g JOIN users u ON u.user_id = g.owner_id
+		 WHERE g.owner_id = $1 AND g.revoked_at IS NULL ORDER BY g.created_at DESC`,
+		ownerID,
+	)
+}
+
+// GetSharedWithMe lists the grants given to a user, including pending
+// invitations sent to their email address
+func GetSharedWithMe(userID int) ([]models.ShareGrant, error) {
+	return queryShareGrants(
+		"SELECT "+shareGrantColumns+` FROM share_grants g JOIN users u ON u.user_id = g.owner_id
+		 WHERE g.revoked_at IS NULL
+		   AND (g.grantee_id = $1
+		        OR (g.grantee_id IS NULL AND LOWER(g.grantee_email) = (SELECT LOWER(email) FROM users WHERE user_id = $1)))
+		 ORDER BY g.created_at DESC`,
+		userID,
+	)
+}
+
+// AcceptShareGrant accepts an invitation sent to the user's email address.
+// The address must be verified so nobody can claim another person's invitation.
+func AcceptShareGrant(userID, grantID int) (*models.ShareGrant, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	result, err := config.DB.Exec(
+		`UPDATE share_grants SET grantee_id = $1, accepted_at = $2
+		 WHERE id = $3 AND grantee_id IS NULL AND revoked_at IS NULL
+		   AND LOWER(grantee_email) = LOWER($4)
+		   AND (expires_at IS NULL OR expires_at > $2)`,
+		userID, time.Now(), grantID, user.Email,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if affected == 0 {
+		return nil, ErrShareGrantNotFound
+	}
+
+	return getShareGrant(grantID)
+}
+
+// RevokeShareGrant ends a grant. Either the owner or the grantee may revoke it.
+func RevokeShareGrant(userID, grantID int) error {
+	result, err := config.DB.Exec(
+		`UPDATE share_grants SET revoked_at = $1
+		 WHERE id = $2 AND revoked_at IS NULL AND (owner_id = $3 OR grantee_id = $3)`,
+		time.Now(), grantID, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrShareGrantNotFound
+	}
+
+	return nil
+}
+
+// HasShareAccess reports whether the grantee may read the given metrics of
+// the owner's data, either through an accepted, unexpired grant covering all
+// of them or as staff of an organization the owner consented to enroll in.
+// Nobody has access to a deactivated account's data, including one awaiting
+// deletion.
+func HasShareAccess(granteeID, ownerID int, metrics []string) (bool, error) {
+	var allowed bool
+
+	err := config.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND is_active) AND (
+		EXISTS (
+			SELECT 1 FROM share_grants
+			WHERE owner_id = $1 AND grantee_id = $2
+			  AND accepted_at IS NOT NULL AND revoked_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > $3)
+			  AND metrics @> $4
+		) OR EXISTS (
+			SELECT 1 FROM patient_enrollments e
+			JOIN organization_members m ON m.org_id = e.org_id
+			WHERE e.patient_id = $1 AND m.user_id = $2 AND e.status = $5
+		))`,
+		ownerID, granteeID, time.Now(), pq.Array(metrics), models.EnrollmentStatusActive,
+	).Scan(&allowed)
+
+	return allowed, err
+}
+
+// dedupeStrings removes repeated values, keeping the first occurrence
+func dedupeStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var result []string
+
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
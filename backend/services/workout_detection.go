@@ -0,0 +1,296 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+const (
+	// detectionLookback limits how far back the detector scans for activity
+	detectionLookback = 3 * time.Hour
+
+	// detectionMaxGap is the longest run of quiet minutes tolerated inside a workout
+	detectionMaxGap = 2 * time.Minute
+
+	// detectionEndGrace is how long activity must have stopped before a
+	// detected workout is considered finished
+	detectionEndGrace = 3 * time.Minute
+
+	// runningCadence is the step cadence (steps/min) above which activity is treated as running
+	runningCadence = 140
+)
+
+// detectionLocks serializes detection per user so concurrent ingestion
+// requests don't create the same workout twice
+var detectionLocks sync.Map
+
+// queuedDetections holds the users with a background detection waiting to
+// start. Uploads arriving meanwhile are covered by that run, so a burst of
+// them queues at most one detection behind the one in progress.
+var queuedDetections sync.Map
+
+// scheduledRechecks holds the users with a detection scheduled for when a
+// stretch that may still be in progress has been idle for detectionEndGrace
+var scheduledRechecks sync.Map
+
+// activityMinute aggregates the steps and heart rate samples of one minute
+type activityMinute struct {
+	Start     time.Time
+	Steps     int
+	HeartRate float64
+	HasHR     bool
+}
+
+// loadActivityMinutes buckets a user's steps and heart rate samples into one-minute bins
+func loadActivityMinutes(userID int, from, to time.Time) ([]activityMinute, error) {
+	query := `
+		SELECT minute, COALESCE(SUM(steps), 0), AVG(heart_rate)
+		FROM (
+			SELECT date_trunc('minute', timestamp) AS minute, steps_count AS steps, NULL::int AS heart_rate
+			FROM steps_data
+			WHERE user_id = $1 AND timestamp >= $2 AND timestamp <= $3
+			UNION ALL
+			SELECT date_trunc('minute', timestamp), NULL::int, heart_rate
+			FROM heart_rate_data
+			WHERE user_id = $1 AND timestamp >= $2 AND timestamp <= $3
+		) samples
+		GROUP BY minute
+		ORDER BY minute
+	`
+
+	rows, err := config.DB.Query(query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var minutes []activityMinute
+
+	for rows.Next() {
+		var minute activityMinute
+		var heartRate *float64
+
+		if err := rows.Scan(&minute.Start, &minute.Steps, &heartRate); err != nil {
+			return nil, err
+		}
+
+		if heartRate != nil {
+			minute.HeartRate = *heartRate
+			minute.HasHR = true
+		}
+
+		minutes = append(minutes, minute)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return minutes, nil
+}
+
+// activityRun is a stretch of elevated activity minutes
+type activityRun struct {
+	Start   time.Time
+	End     time.Time
+	Minutes []activityMinute
+}
+
+// findActivityRuns groups elevated minutes into runs, bridging quiet gaps of up to detectionMaxGap
+func findActivityRuns(minutes []activityMinute, cadenceThreshold, heartRateThreshold int) []activityRun {
+	var runs []activityRun
+	var current *activityRun
+
+	for _, minute := range minutes {
+		elevated := minute.Steps >= cadenceThreshold ||
+			(minute.HasHR && minute.HeartRate >= float64(heartRateThreshold))
+		if !elevated {
+			continue
+		}
+
+		if current != nil && minute.Start.Sub(current.End) <= detectionMaxGap {
+			current.End = minute.Start.Add(time.Minute)
+			current.Minutes = append(current.Minutes, minute)
+			continue
+		}
+
+		if current != nil {
+			runs = append(runs, *current)
+		}
+		current = &activityRun{
+			Start:   minute.Start,
+			End:     minute.Start.Add(time.Minute),
+			Minutes: []activityMinute{minute},
+		}
+	}
+
+	if current != nil {
+		runs = append(runs, *current)
+	}
+
+	return runs
+}
+
+// classifyActivityRun guesses the workout type from the average cadence of a run
+func classifyActivityRun(run activityRun, cadenceThreshold int) string {
+	totalSteps := 0
+	for _, minute := range run.Minutes {
+		totalSteps += minute.Steps
+	}
+	cadence := float64(totalSteps) / run.End.Sub(run.Start).Minutes()
+
+	switch {
+	case cadence >= runningCadence:
+		return "running"
+	case cadence >= float64(cadenceThreshold):
+		return "walking"
+	default:
+		return "cardio"
+	}
+}
+
+// DetectWorkouts looks for sustained elevated activity in a user's recent
+// steps and heart rate samples and records each finished stretch as a
+// tentative workout. Stretches overlapping an existing workout are ignored.
+func DetectWorkouts(userID int) ([]models.Workout, error) {
+	lock := detectionLock(userID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return detectWorkouts(userID)
+}
+
+// detectionLock returns the mutex serializing a user's detections
+func detectionLock(userID int) *sync.Mutex {
+	lock, _ := detectionLocks.LoadOrStore(userID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// detectWorkouts runs detection for a user whose detection lock is held
+func detectWorkouts(userID int) ([]models.Workout, error) {
+	minDuration := config.GetEnvDuration("WORKOUT_DETECTION_MIN_DURATION", 10*time.Minute)
+	cadenceThreshold := config.GetEnvInt("WORKOUT_DETECTION_CADENCE", 100)
+	heartRateThreshold := config.GetEnvInt("WORKOUT_DETECTION_HEART_RATE", 110)
+
+	now := time.Now()
+	from := now.Add(-detectionLookback)
+
+	// Manual sessions take precedence over detection
+	var activeCount int
+	var lastEnd *time.Time
+	err := config.DB.QueryRow(
+		`SELECT COUNT(*) FILTER (WHERE status = $2), MAX(end_time)
+		 FROM workouts WHERE user_id = $1 AND (end_time IS NULL OR end_time >= $3)`,
+		userID, models.WorkoutStatusActive, from,
+	).Scan(&activeCount, &lastEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if activeCount > 0 {
+		return nil, nil
+	}
+
+	if lastEnd != nil && lastEnd.After(from) {
+		from = *lastEnd
+	}
+
+	minutes, err := loadActivityMinutes(userID, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var detected []models.Workout
+
+	for _, run := range findActivityRuns(minutes, cadenceThreshold, heartRateThreshold) {
+		// Skip stretches that are too short or may still be in progress
+		if run.End.Sub(run.Start) < minDuration {
+			continue
+		}
+
+		// Check again once the stretch has been idle long enough, in case
+		// it ended with the upload that triggered this run
+		if idle := now.Sub(run.End); idle < detectionEndGrace {
+			scheduleDetectionRecheck(userID, detectionEndGrace-idle+time.Second)
+			continue
+		}
+
+		// Runs starting exactly at the previous workout's end belong to it
+		if lastEnd != nil && !run.Start.After(*lastEnd) {
+			continue
+		}
+
+		query := `
+			INSERT INTO workouts (user_id, workout_type, status, detected, start_time, end_time)
+			VALUES ($1, $2, $3, true, $4, $5)
+			RETURNING ` + workoutColumns
+
+		workout, err := scanWorkout(config.DB.QueryRow(
+			query,
+			userID,
+			classifyActivityRun(run, cadenceThreshold),
+			models.WorkoutStatusTentative,
+			run.Start,
+			run.End,
+		))
+		if err != nil {
+			return detected, err
+		}
+
+		workout, err = finalizeWorkout(workout)
+		if err != nil {
+			return detected, err
+		}
+
+		detected = append(detected, *workout)
+	}
+
+	return detected, nil
+}
+
+// scheduleDetectionRecheck runs detection for a user after a delay, unless a
+// re-check is already scheduled
+func scheduleDetectionRecheck(userID int, delay time.Duration) {
+	if _, scheduled := scheduledRechecks.LoadOrStore(userID, struct{}{}); scheduled {
+		return
+	}
+
+	time.AfterFunc(delay, func() {
+		scheduledRechecks.Delete(userID)
+		DetectWorkoutsAsync(userID)
+	})
+}
+
+// SamplesIngested starts the background work that new samples of a user may
+// call for: they may complete a stretch of sustained activity, fill a window
+// whose calories the device didn't report, or breach a heart rate threshold
+func SamplesIngested(userID int) {
+	DetectWorkoutsAsync(userID)
+	EstimateCaloriesAsync(userID)
+	CheckPatientAlertsAsync(userID)
+}
+
+// DetectWorkoutsAsync runs workout detection in the background after new
+// samples arrive, unless a run for the user is already queued
+func DetectWorkoutsAsync(userID int) {
+	if _, queued := queuedDetections.LoadOrStore(userID, struct{}{}); queued {
+		return
+	}
+
+	go func() {
+		lock := detectionLock(userID)
+		lock.Lock()
+		defer lock.Unlock()
+
+		// Samples arriving from here on need a new run to be seen
+		queuedDetections.Delete(userID)
+
+		if _, err := detectWorkouts(userID); err != nil {
+			log.Printf("Workout detection failed for user %d: %v", userID, err)
+		}
+	}()
+}
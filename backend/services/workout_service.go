@@ -0,0 +1,530 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/habdil/notify-vital/backend/config"
+	"github.com/habdil/notify-vital/backend/models"
+)
+
+var (
+	ErrWorkoutNotFound   = errors.New("workout not found")
+	ErrWorkoutInProgress = errors.New("a workout is already in progress")
+	ErrWorkoutNotActive  = errors.New("workout is not active")
+	ErrWorkoutNotPending = errors.New("workout is not awaiting confirmation")
+)
+
+// defaultMaxHeartRate is used for zone calculation when the user's max heart rate is unknown
+const defaultMaxHeartRate = 190
+
+// maxSampleGap caps how long a single heart rate sample is assumed to last,
+// so gaps in the data are not attributed to a zone
+const maxSampleGap = 2 * time.Minute
+
+const workoutColumns = `
+	workout_id, user_id, device_id, workout_type, status, detected, start_time, end_time,
+	duration_seconds, avg_heart_rate, max_heart_rate, total_steps, distance,
+	calories_burned, heart_rate_zones, created_at, updated_at
+`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWorkout reads a single workout row selected with workoutColumns
+func scanWorkout(row rowScanner) (*models.Workout, error) {
+	var workout models.Workout
+	var deviceID sql.NullInt32
+	var endTime sql.NullTime
+	var avgHeartRate sql.NullFloat64
+	var maxHeartRate sql.NullInt32
+	var zones []byte
+
+	err := row.Scan(
+		&workout.WorkoutID, &workout.UserID, &deviceID, &workout.WorkoutType, &workout.Status,
+		&workout.Detected, &workout.StartTime, &endTime, &workout.DurationSeconds, &avgHeartRate, &maxHeartRate,
+		&workout.TotalSteps, &workout.Distance, &workout.CaloriesBurned, &zones,
+		&workout.CreatedAt, &workout.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if deviceID.Valid {
+		deviceIDInt := int(deviceID.Int32)
+		workout.DeviceID = &deviceIDInt
+	}
+
+	if endTime.Valid {
+		endTimeValue := endTime.Time
+		workout.EndTime = &endTimeValue
+	}
+
+	if avgHeartRate.Valid {
+		avgHeartRateFloat := avgHeartRate.Float64
+		workout.AvgHeartRate = &avgHeartRateFloat
+	}
+
+	if maxHeartRate.Valid {
+		maxHeartRateInt := int(maxHeartRate.Int32)
+		workout.MaxHeartRate = &maxHeartRateInt
+	}
+
+	if len(zones) > 0 {
+		if err := json.Unmarshal(zones, &workout.HeartRateZones); err != nil {
+			return nil, err
+		}
+	}
+
+	return &workout, nil
+}
+
+// StartWorkout opens a new workout session for a user
+func StartWorkout(userID int, req models.StartWorkoutRequest) (*models.Workout, error) {
+	var activeCount int
+	err := config.DB.QueryRow(
+		"SELECT COUNT(*) FROM workouts WHERE user_id = $1 AND status = $2",
+		userID, models.WorkoutStatusActive,
+	).Scan(&activeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if activeCount > 0 {
+		return nil, ErrWorkoutInProgress
+	}
+
+	query := `
+		INSERT INTO workouts (user_id, device_id, workout_type, status, start_time)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + workoutColumns
+
+	return scanWorkout(config.DB.QueryRow(
+		query,
+		userID,
+		req.DeviceID,
+		req.WorkoutType,
+		models.WorkoutStatusActive,
+		time.Now(),
+	))
+}
+
+// StopWorkout ends an active workout, links the samples recorded during it
+// and stores the computed session metrics
+func StopWorkout(userID, workoutID int) (*models.Workout, error) {
+	workout, err := GetWorkoutByID(userID, workoutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workout.Status != models.WorkoutStatusActive {
+		return nil, ErrWorkoutNotActive
+	}
+
+	endTime := time.Now()
+	workout.EndTime = &endTime
+	workout.Status = models.WorkoutStatusCompleted
+
+	return finalizeWorkout(workout)
+}
+
+// finalizeWorkout links samples in the workout window to the workout,
+// computes its metrics and persists the result in a single transaction
+func finalizeWorkout(workout *models.Workout) (*models.Workout, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := linkWorkoutSamples(tx, workout); err != nil {
+		return nil, err
+	}
+
+	if err := computeWorkoutStats(tx, workout); err != nil {
+		return nil, err
+	}
+
+	zones, err := json.Marshal(workout.HeartRateZones)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE workouts SET
+			workout_type = $1, status = $2, start_time = $3, end_time = $4,
+			duration_seconds = $5, avg_heart_rate = $6, max_heart_rate = $7,
+			total_steps = $8, distance = $9, calories_burned = $10,
+			heart_rate_zones = $11, updated_at = $12
+		WHERE workout_id = $13
+		RETURNING ` + workoutColumns
+
+	updated, err := scanWorkout(tx.QueryRow(
+		query,
+		workout.WorkoutType,
+		workout.Status,
+		workout.StartTime,
+		workout.EndTime,
+		workout.DurationSeconds,
+		workout.AvgHeartRate,
+		workout.MaxHeartRate,
+		workout.TotalSteps,
+		workout.Distance,
+		workout.CaloriesBurned,
+		zones,
+		time.Now(),
+		workout.WorkoutID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// linkWorkoutSamples attaches heart rate, steps and calories samples recorded
+// in the workout window to the workout. Samples without an activity type
+// inherit the workout type.
+func linkWorkoutSamples(tx *sql.Tx, workout *models.Workout) error {
+	window := `user_id = $2 AND timestamp >= $3 AND timestamp <= $4 AND ($5::int IS NULL OR device_id = $5)`
+	args := []interface{}{workout.WorkoutID, workout.UserID, workout.StartTime, workout.EndTime, workout.DeviceID}
+
+	_, err := tx.Exec("UPDATE steps_data SET workout_id = $1 WHERE "+window, args...)
+	if err != nil {
+		return err
+	}
+
+	args = append(args, workout.WorkoutType)
+
+	for _, table := range []string{"heart_rate_data", "calories_data"} {
+		query := `UPDATE ` + table + `
+			SET workout_id = $1,
+			    activity_type_inferred = activity_type_inferred OR activity_type IS NULL,
+			    activity_type = COALESCE(activity_type, $6)
+			WHERE ` + window
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// computeWorkoutStats fills in duration, heart rate, zone, steps, distance
+// and calories metrics for a workout from the samples linked to it
+func computeWorkoutStats(tx *sql.Tx, workout *models.Workout) error {
+	end := time.Now()
+	if workout.EndTime != nil {
+		end = *workout.EndTime
+	}
+	workout.DurationSeconds = int(end.Sub(workout.StartTime).Seconds())
+
+	rows, err := tx.Query(
+		"SELECT timestamp, heart_rate FROM heart_rate_data WHERE workout_id = $1 ORDER BY timestamp",
+		workout.WorkoutID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var timestamps []time.Time
+	var heartRates []int
+
+	for rows.Next() {
+		var timestamp time.Time
+		var heartRate int
+
+		if err := rows.Scan(&timestamp, &heartRate); err != nil {
+			return err
+		}
+
+		timestamps = append(timestamps, timestamp)
+		heartRates = append(heartRates, heartRate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	workout.AvgHeartRate = nil
+	workout.MaxHeartRate = nil
+	workout.HeartRateZones = models.HeartRateZones{}
+
+	if len(heartRates) > 0 {
+		total := 0
+		maxHeartRate := 0
+		for _, heartRate := range heartRates {
+			total += heartRate
+			if heartRate > maxHeartRate {
+				maxHeartRate = heartRate
+			}
+		}
+
+		avgHeartRate := float64(total) / float64(len(heartRates))
+		workout.AvgHeartRate = &avgHeartRate
+		workout.MaxHeartRate = &maxHeartRate
+		user, err := GetUserByID(workout.UserID)
+		if err != nil {
+			return err
+		}
+		workout.HeartRateZones = computeHeartRateZones(timestamps, heartRates, end, maxHeartRateForUser(user))
+	}
+
+	err = tx.QueryRow(
+		"SELECT COALESCE(SUM(steps_count), 0) FROM steps_data WHERE workout_id = $1",
+		workout.WorkoutID,
+	).Scan(&workout.TotalSteps)
+	if err != nil {
+		return err
+	}
+
+	profile, err := loadStrideProfile(workout.UserID)
+	if err != nil {
+		return err
+	}
+
+	distanceQuery := `
+		SELECT steps_count, distance, interval_seconds
+		FROM (
+			SELECT *, ` + stepsIntervalColumn + ` AS interval_seconds
+			FROM steps_data
+			WHERE user_id = $1 AND ` + stepsIntervalFrom("$3") + ` AND timestamp <= $4
+		) steps
+		WHERE workout_id = $2
+	`
+
+	workout.Distance, err = sumStepsDistance(tx, profile, distanceQuery, workout.UserID, workout.WorkoutID, workout.StartTime, end)
+	if err != nil {
+		return err
+	}
+
+	return tx.QueryRow(
+		"SELECT COALESCE(SUM(calories_burned), 0) FROM calories_data WHERE workout_id = $1",
+		workout.WorkoutID,
+	).Scan(&workout.CaloriesBurned)
+}
+
+// computeHeartRateZones attributes the time between consecutive samples to
+// the zone of the earlier sample. Samples must be ordered by timestamp.
+func computeHeartRateZones(timestamps []time.Time, heartRates []int, end time.Time, maxHeartRate int) models.HeartRateZones {
+	var zones models.HeartRateZones
+
+	for i, heartRate := range heartRates {
+		next := end
+		if i+1 < len(timestamps) {
+			next = timestamps[i+1]
+		}
+
+		span := next.Sub(timestamps[i])
+		if span > maxSampleGap {
+			span = maxSampleGap
+		}
+		if span <= 0 {
+			continue
+		}
+
+		seconds := int(span.Seconds())
+		percent := float64(heartRate) / float64(maxHeartRate) * 100
+
+		switch {
+		case percent >= 90:
+			zones.Zone5 += seconds
+		case percent >= 80:
+			zones.Zone4 += seconds
+		case percent >= 70:
+			zones.Zone3 += seconds
+		case percent >= 60:
+			zones.Zone2 += seconds
+		case percent >= 50:
+			zones.Zone1 += seconds
+		}
+	}
+
+	return zones
+}
+
+// ConfirmWorkout accepts a tentative, automatically detected workout
+func ConfirmWorkout(userID, workoutID int) (*models.Workout, error) {
+	workout, err := GetWorkoutByID(userID, workoutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workout.Status != models.WorkoutStatusTentative {
+		return nil, ErrWorkoutNotPending
+	}
+
+	query := "UPDATE workouts SET status = $1, updated_at = $2 WHERE workout_id = $3 RETURNING " + workoutColumns
+
+	return scanWorkout(config.DB.QueryRow(query, models.WorkoutStatusCompleted, time.Now(), workoutID))
+}
+
+// RelabelWorkout changes the type of a workout. Linked samples that
+// inherited the old type are relabeled along with it.
+func RelabelWorkout(userID, workoutID int, req models.UpdateWorkoutRequest) (*models.Workout, error) {
+	workout, err := GetWorkoutByID(userID, workoutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if workout.Status == models.WorkoutStatusDiscarded {
+		return nil, ErrWorkoutNotFound
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"heart_rate_data", "calories_data"} {
+		query := "UPDATE " + table + " SET activity_type = $1 WHERE workout_id = $2 AND activity_type_inferred"
+		if _, err := tx.Exec(query, req.WorkoutType, workoutID); err != nil {
+			return nil, err
+		}
+	}
+
+	query := "UPDATE workouts SET workout_type = $1, updated_at = $2 WHERE workout_id = $3 RETURNING " + workoutColumns
+
+	updated, err := scanWorkout(tx.QueryRow(query, req.WorkoutType, time.Now(), workoutID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DiscardWorkout rejects a tentative workout and unlinks its samples. The
+// record is kept so the same window is not detected again.
+func DiscardWorkout(userID, workoutID int) error {
+	workout, err := GetWorkoutByID(userID, workoutID)
+	if err != nil {
+		return err
+	}
+
+	if workout.Status != models.WorkoutStatusTentative {
+		return ErrWorkoutNotPending
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE steps_data SET workout_id = NULL WHERE workout_id = $1", workoutID); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"heart_rate_data", "calories_data"} {
+		query := `UPDATE ` + table + `
+			SET workout_id = NULL,
+			    activity_type = CASE WHEN activity_type_inferred THEN NULL ELSE activity_type END,
+			    activity_type_inferred = FALSE
+			WHERE workout_id = $1`
+		if _, err := tx.Exec(query, workoutID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE workouts SET status = $1, updated_at = $2 WHERE workout_id = $3",
+		models.WorkoutStatusDiscarded, time.Now(), workoutID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWorkoutByID retrieves a single workout owned by the user
+func GetWorkoutByID(userID, workoutID int) (*models.Workout, error) {
+	query := "SELECT " + workoutColumns + " FROM workouts WHERE workout_id = $1 AND user_id = $2"
+
+	workout, err := scanWorkout(config.DB.QueryRow(query, workoutID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWorkoutNotFound
+		}
+		return nil, err
+	}
+
+	return workout, nil
+}
+
+// GetWorkouts retrieves the workouts of a user
+func GetWorkouts(userID int, filters models.WorkoutFilters) ([]models.Workout, error) {
+	var workouts []models.Workout
+
+	query := "SELECT " + workoutColumns + " FROM workouts WHERE user_id = $1"
+
+	args := []interface{}{userID}
+	argCount := 2
+
+	if filters.WorkoutType != "" {
+		query += fmt.Sprintf(" AND workout_type = $%d", argCount)
+		args = append(args, filters.WorkoutType)
+		argCount++
+	}
+
+	if filters.Status != "" {
+		query += fmt.Sprintf(" AND status = $%d", argCount)
+		args = append(args, filters.Status)
+		argCount++
+	} else {
+		// Discarded detections are only listed when asked for explicitly
+		query += fmt.Sprintf(" AND status <> $%d", argCount)
+		args = append(args, models.WorkoutStatusDiscarded)
+		argCount++
+	}
+
+	if filters.StartDate != "" {
+		query += fmt.Sprintf(" AND start_time >= $%d", argCount)
+		args = append(args, filters.StartDate)
+		argCount++
+	}
+
+	if filters.EndDate != "" {
+		query += fmt.Sprintf(" AND start_time <= $%d", argCount)
+		args = append(args, filters.EndDate)
+		argCount++
+	}
+
+	query += fmt.Sprintf(" ORDER BY start_time DESC LIMIT $%d OFFSET $%d", argCount, argCount+1)
+	args = append(args, filters.Limit, filters.Offset)
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		workout, err := scanWorkout(rows)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, *workout)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return workouts, nil
+}